@@ -10,10 +10,12 @@ import (
 	"syscall"
 	"time"
 
+	"torgo/internal/admin"
 	"torgo/internal/chaff"
 	"torgo/internal/config"
 	"torgo/internal/dns"
 	"torgo/internal/health"
+	"torgo/internal/metrics"
 	"torgo/internal/secmem"
 	"torgo/internal/selfcheck"
 	"torgo/internal/socks"
@@ -53,10 +55,13 @@ func main() {
 	cfg := config.Load()
 	slog.Info("torgo zero-trust starting", "instances", cfg.Instances)
 
+	// Subscribe before any tor starts: a child dying mid-bootstrap must
+	// still reach the restart policy
+	exits := config.SubscribeExits()
 	instances := startTorInstances(cfg)
 
-	// 4. Wait for Bootstrap (No Self-Healing)
-	waitForTorReady(instances)
+	// 4. Wait for Bootstrap quorum (healing is opt-in: TORGO_RESTART_POLICY)
+	waitForTorReady(instances, cfg)
 
 	// 5. Graceful Shutdown Context
 	ctx, cancel := signal.NotifyContext(
@@ -69,9 +74,14 @@ func main() {
 
 	// 6. Start Services
 	go socks.Start(ctx, instances, cfg)
+	go socks.StartHTTP(ctx, instances, cfg)
+	go socks.StartTransparent(ctx, instances, cfg)
 	go dns.Start(ctx, instances, cfg)
-	go health.Monitor(ctx, instances)
+	go health.Monitor(ctx, instances, cfg, exits)
+	go metrics.Start(ctx, instances, cfg)
+	go admin.Start(ctx, instances, cfg)
 	go chaff.Start(ctx, cfg) // Deep Surfing Enabled
+	go announceLateInstances(ctx, instances)
 
 	slog.Info("torgo active — SOCKS 9150 | DNS 5353 — memory locked and non-dumpable")
 
@@ -87,11 +97,7 @@ func main() {
 func startTorInstances(cfg *config.Config) []*config.Instance {
 	var insts []*config.Instance
 	for i := 1; i <= cfg.Instances; i++ {
-		inst := &config.Instance{
-			ID:        i,
-			SocksPort: 9050 + i,
-			DNSPort:   9200 + i,
-		}
+		inst := config.NewInstance(i, 9050+i, 9200+i)
 		if err := inst.Start(); err != nil {
 			slog.Error("tor failed to start", "id", i, "err", err)
 			continue
@@ -102,18 +108,29 @@ func startTorInstances(cfg *config.Config) []*config.Instance {
 		slog.Error("no tor instances started — exiting")
 		os.Exit(1)
 	}
+	if q := cfg.ReadyQuorum(); len(insts) < q {
+		slog.Error("too few tor instances started to ever reach quorum — exiting",
+			"started", len(insts), "quorum", q)
+		os.Exit(1)
+	}
 	return insts
 }
 
-func waitForTorReady(insts []*config.Instance) {
-	// 3 minute absolute timeout
-	deadline := time.Now().Add(180 * time.Second)
-	slog.Info("waiting for tor instances to bootstrap...")
+func waitForTorReady(insts []*config.Instance, cfg *config.Config) {
+	// Absolute timeout (TORGO_BOOTSTRAP_TIMEOUT_SECS, default 3 minutes)
+	timeout := time.Duration(cfg.BootstrapTimeoutSeconds) * time.Second
+	deadline := time.Now().Add(timeout)
+	quorum := cfg.ReadyQuorum()
+	slog.Info("waiting for tor instances to bootstrap...", "timeout", timeout, "quorum", quorum)
 
+	lastReport := time.Now()
 	for time.Now().Before(deadline) {
 		readyCount := 0
 		for _, inst := range insts {
-			// Use shared strict check
+			// Ready = tor says "Bootstrapped 100%" AND the SOCKS port answers
+			if !inst.Ready() {
+				continue
+			}
 			if err := health.CheckSocks(inst.SocksPort); err == nil {
 				readyCount++
 			}
@@ -123,13 +140,59 @@ func waitForTorReady(insts []*config.Instance) {
 			slog.Info("all tor instances ready", "count", len(insts))
 			return
 		}
+		if readyCount >= quorum {
+			// Stragglers join the pools on their own once bootstrapped
+			slog.Info("tor quorum ready — serving",
+				"ready", readyCount,
+				"total", len(insts),
+				"quorum", quorum,
+			)
+			return
+		}
+
+		if time.Since(lastReport) >= 10*time.Second {
+			lastReport = time.Now()
+			slog.Info("bootstrap in progress", "ready", readyCount, "total", len(insts), "quorum", quorum)
+		}
 		time.Sleep(1 * time.Second)
 	}
-	
-	slog.Error("timeout waiting for tor instances — aborting")
+
+	for _, inst := range insts {
+		pct, tag := inst.Bootstrap()
+		slog.Error("instance not ready at timeout", "id", inst.ID, "percent", pct, "tag", tag)
+	}
+	slog.Error("timeout waiting for tor quorum — aborting", "quorum", quorum)
 	os.Exit(1)
 }
 
+// announceLateInstances logs instances that finish bootstrapping after the
+// quorum was reached. Pickers include them as soon as Ready() flips.
+func announceLateInstances(ctx context.Context, insts []*config.Instance) {
+	pending := make(map[*config.Instance]bool)
+	for _, inst := range insts {
+		if !inst.Ready() {
+			pending[inst] = true
+		}
+	}
+
+	ticker := time.NewTicker(2 * time.Second)
+	defer ticker.Stop()
+
+	for len(pending) > 0 {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			for inst := range pending {
+				if inst.Ready() {
+					delete(pending, inst)
+					slog.Info("late tor instance joined pool", "id", inst.ID, "pending", len(pending))
+				}
+			}
+		}
+	}
+}
+
 func killAllTor(insts []*config.Instance) {
 	for _, inst := range insts {
 		inst.Close()
@@ -0,0 +1,309 @@
+// internal/admin/admin.go — LOCAL ADMIN API (UNIX SOCKET ONLY, OFF BY DEFAULT)
+package admin
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+
+	"torgo/internal/config"
+	"torgo/internal/dns"
+	"torgo/internal/registry"
+	"torgo/internal/socks"
+)
+
+type ctxKey struct{}
+
+// peer is the SO_PEERCRED identity of the process on the other end.
+type peer struct {
+	uid int
+	pid int
+}
+
+// Start serves the admin API on cfg.AdminSocket (TORGO_ADMIN_SOCKET, under
+// /run). Nothing listens unless a socket path and a token are both configured;
+// every request must come from an allowed UID and carry the token.
+//
+//	GET  /instances               pool state per instance
+//	POST /instances/{id}/rotate   force a rotation (NEWNYM or drain + restart)
+//	POST /instances/{id}/drain    stop routing new traffic to the instance
+//	POST /instances/{id}/undrain  route to it again
+//	GET  /config                  effective configuration (secrets omitted)
+func Start(ctx context.Context, insts []*config.Instance, cfg *config.Config) {
+	if cfg.AdminSocket == "" {
+		return
+	}
+	if cfg.AdminToken == "" {
+		slog.Error("admin api disabled — no token (TORGO_ADMIN_TOKEN_FILE)")
+		return
+	}
+
+	l, err := listen(cfg.AdminSocket)
+	if err != nil {
+		slog.Error("admin bind failed", "err", err)
+		return
+	}
+
+	a := &api{insts: insts, cfg: cfg}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /instances", a.listInstances)
+	mux.HandleFunc("POST /instances/{id}/rotate", a.rotate)
+	mux.HandleFunc("POST /instances/{id}/drain", a.drain(true))
+	mux.HandleFunc("POST /instances/{id}/undrain", a.drain(false))
+	mux.HandleFunc("GET /config", a.showConfig)
+
+	srv := &http.Server{
+		Handler:           a.auth(mux),
+		ReadHeaderTimeout: 5 * time.Second,
+		ReadTimeout:       10 * time.Second,
+		WriteTimeout:      10 * time.Second,
+		MaxHeaderBytes:    8 << 10,
+		ErrorLog:          log.New(io.Discard, "", 0),
+		ConnContext: func(ctx context.Context, c net.Conn) context.Context {
+			if p, err := peerCred(c); err == nil {
+				return context.WithValue(ctx, ctxKey{}, p)
+			}
+			return ctx
+		},
+	}
+	go func() {
+		<-ctx.Done()
+		_ = srv.Close()
+		_ = os.Remove(cfg.AdminSocket)
+	}()
+
+	slog.Info("admin api active", "socket", cfg.AdminSocket, "uids", cfg.AdminAllowUIDs)
+	if err := srv.Serve(l); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		slog.Error("admin api stopped", "err", err)
+	}
+}
+
+// listen binds the admin socket inside a private directory (config.Load
+// keeps it under /run). The directory is 0700 and ours before the socket
+// exists, so nobody else can reach the socket even for the moment before it
+// is narrowed to 0600; no process-wide umask games needed.
+func listen(path string) (net.Listener, error) {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	fi, err := os.Lstat(dir)
+	if err != nil {
+		return nil, err
+	}
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !fi.IsDir() || !ok || int(st.Uid) != os.Geteuid() {
+		return nil, fmt.Errorf("admin: %s is not a directory owned by uid %d", dir, os.Geteuid())
+	}
+	if fi.Mode().Perm() != 0o700 {
+		if err := os.Chmod(dir, 0o700); err != nil {
+			return nil, err
+		}
+	}
+
+	if fi, err := os.Lstat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		_ = os.Remove(path) // stale socket from a previous run
+	}
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	// Owner only: the peer UID check is the second lock, not the first
+	if err := os.Chmod(path, 0o600); err != nil {
+		l.Close()
+		return nil, fmt.Errorf("admin: chmod %s: %w", path, err)
+	}
+	return l, nil
+}
+
+func peerCred(c net.Conn) (peer, error) {
+	uc, ok := c.(*net.UnixConn)
+	if !ok {
+		return peer{}, errors.New("admin: not a unix socket")
+	}
+	raw, err := uc.SyscallConn()
+	if err != nil {
+		return peer{}, err
+	}
+	var cred *unix.Ucred
+	var cerr error
+	if err := raw.Control(func(fd uintptr) {
+		cred, cerr = unix.GetsockoptUcred(int(fd), unix.SOL_SOCKET, unix.SO_PEERCRED)
+	}); err != nil {
+		return peer{}, err
+	}
+	if cerr != nil {
+		return peer{}, cerr
+	}
+	return peer{uid: int(cred.Uid), pid: int(cred.Pid)}, nil
+}
+
+type api struct {
+	insts []*config.Instance
+	cfg   *config.Config
+}
+
+// auth enforces both locks: allowed peer UID, then the bearer token
+// (constant-time compare).
+func (a *api) auth(next http.Handler) http.Handler {
+	want := []byte("Bearer " + a.cfg.AdminToken)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p, ok := r.Context().Value(ctxKey{}).(peer)
+		if !ok || !slices.Contains(a.cfg.AdminAllowUIDs, p.uid) {
+			slog.Warn("admin request refused — peer uid not allowed", "uid", p.uid, "pid", p.pid)
+			writeErr(w, http.StatusForbidden, "forbidden")
+			return
+		}
+		got := []byte(r.Header.Get("Authorization"))
+		if subtle.ConstantTimeCompare(got, want) != 1 {
+			slog.Warn("admin request refused — bad token", "uid", p.uid, "pid", p.pid)
+			writeErr(w, http.StatusUnauthorized, "unauthorized")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+type instanceView struct {
+	ID            int    `json:"id"`
+	Tier          string `json:"tier"`
+	Running       bool   `json:"running"`
+	Ready         bool   `json:"ready"`
+	Bootstrap     int    `json:"bootstrap"`
+	Healthy       bool   `json:"healthy"`
+	Failed        bool   `json:"failed"`
+	Drained       bool   `json:"drained"`
+	Draining      bool   `json:"draining"`
+	Active        uint32 `json:"active"`
+	DNSActive     uint32 `json:"dnsActive"`
+	SinceRotation uint64 `json:"sinceRotation"`
+	SoftRotations uint64 `json:"softRotations"`
+	HardRotations uint64 `json:"hardRotations"`
+	UptimeSecs    int64  `json:"uptimeSecs"`
+	LastRotation  int64  `json:"lastRotation"`
+}
+
+func (a *api) listInstances(w http.ResponseWriter, r *http.Request) {
+	stats := socks.Snapshot(a.insts)
+	ds := dns.Snapshot()
+	out := make([]instanceView, 0, len(stats))
+	for idx, s := range stats {
+		inst := a.insts[idx]
+		pct, _ := inst.Bootstrap()
+		v := instanceView{
+			ID:            s.ID,
+			Tier:          s.Tier,
+			Running:       inst.Running(),
+			Ready:         inst.Ready(),
+			Bootstrap:     pct,
+			Healthy:       registry.Healthy(idx),
+			Failed:        registry.Failed(idx),
+			Drained:       registry.Drained(idx),
+			Draining:      s.Draining,
+			Active:        s.Active,
+			DNSActive:     ds.PerInstance[idx],
+			SinceRotation: s.SinceRotation,
+			SoftRotations: s.SoftRotations,
+			HardRotations: s.HardRotations,
+			LastRotation:  s.LastRotation,
+		}
+		if started := inst.StartedAt(); inst.Running() && !started.IsZero() {
+			v.UptimeSecs = int64(time.Since(started) / time.Second)
+		}
+		out = append(out, v)
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+func (a *api) rotate(w http.ResponseWriter, r *http.Request) {
+	id, ok := a.instanceID(w, r)
+	if !ok {
+		return
+	}
+	mode, err := socks.Rotate(id)
+	if err != nil {
+		writeErr(w, http.StatusConflict, err.Error())
+		return
+	}
+	a.audit(r, "rotate", id)
+	writeJSON(w, http.StatusAccepted, map[string]any{"id": id, "mode": mode})
+}
+
+func (a *api) drain(on bool) http.HandlerFunc {
+	action := "undrain"
+	if on {
+		action = "drain"
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, ok := a.instanceID(w, r)
+		if !ok {
+			return
+		}
+		idx := a.slot(id)
+		was := registry.SetDrained(idx, on)
+		a.audit(r, action, id)
+		writeJSON(w, http.StatusOK, map[string]any{"id": id, "drained": on, "changed": was != on})
+	}
+}
+
+func (a *api) showConfig(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, struct {
+		*config.Config
+		RouteRules int
+	}{a.cfg, a.cfg.Routes.Len()})
+}
+
+// instanceID parses {id} and checks it names a pooled instance.
+func (a *api) instanceID(w http.ResponseWriter, r *http.Request) (int, bool) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || a.slot(id) < 0 {
+		writeErr(w, http.StatusNotFound, "unknown instance")
+		return 0, false
+	}
+	return id, true
+}
+
+func (a *api) slot(id int) int {
+	for idx, inst := range a.insts {
+		if idx >= 32 {
+			break
+		}
+		if inst.ID == id {
+			return idx
+		}
+	}
+	return -1
+}
+
+func (a *api) audit(r *http.Request, action string, id int) {
+	p, _ := r.Context().Value(ctxKey{}).(peer)
+	slog.Info("admin action", "action", action, "id", id, "uid", p.uid, "pid", p.pid)
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(v)
+}
+
+func writeErr(w http.ResponseWriter, code int, msg string) {
+	writeJSON(w, code, map[string]string{"error": strings.TrimSpace(msg)})
+}
@@ -101,17 +101,18 @@ func Start(ctx context.Context, cfg *config.Config) {
 
 	// 2. Start DNS Noise (UDP/TCP to local Tor DNS port)
 	// This generates dummy DNS lookups to mask the timing of any REAL lookups you do.
-	go dnsNoiseLoop(ctx, cfg.DNSPort)
+	go dnsNoiseLoop(ctx, cfg.DNSListeners)
 }
 
 // --- DNS NOISE GENERATOR ---
 
-func dnsNoiseLoop(ctx context.Context, dnsPort string) {
+func dnsNoiseLoop(ctx context.Context, listeners []config.Listener) {
 	// Create a custom resolver that talks to our local Tor DNS port
+	dnsNet, dnsAddr := config.LocalDial(listeners)
 	resolver := &net.Resolver{
 		PreferGo: true,
 		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
-			return net.Dial("tcp", "127.0.0.1:"+dnsPort)
+			return net.Dial(dnsNet, dnsAddr)
 		},
 	}
 
@@ -0,0 +1,175 @@
+package config
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// tor stdout: "... [notice] Bootstrapped 45% (requesting_descriptors): Asking for relay descriptors"
+var bootstrapLogRe = regexp.MustCompile(`Bootstrapped (\d+)%(?: \(([a-z_]+)\))?: (.*)`)
+
+// Bootstrap returns the last reported bootstrap percent and phase tag.
+func (i *Instance) Bootstrap() (int, string) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return int(atomic.LoadInt32(&i.bootPct)), i.bootTag
+}
+
+// Bootstrapped reports whether tor has reached "Bootstrapped 100%".
+func (i *Instance) Bootstrapped() bool {
+	return atomic.LoadInt32(&i.bootPct) >= 100
+}
+
+func (i *Instance) resetBootstrap() {
+	i.mu.Lock()
+	atomic.StoreInt32(&i.bootPct, 0)
+	i.bootTag = "starting"
+	i.mu.Unlock()
+}
+
+// setBootstrap records progress from tracker generation gen; stale trackers
+// (from a process that has since been restarted) are ignored.
+func (i *Instance) setBootstrap(gen uint32, pct int, tag, summary string) {
+	i.mu.Lock()
+	if atomic.LoadUint32(&i.gen) != gen || int32(pct) <= atomic.LoadInt32(&i.bootPct) {
+		i.mu.Unlock()
+		return
+	}
+	atomic.StoreInt32(&i.bootPct, int32(pct))
+	i.bootTag = tag
+	i.mu.Unlock()
+
+	if pct >= 100 {
+		slog.Info("tor instance bootstrapped", "id", i.ID)
+		return
+	}
+	slog.Info("tor bootstrap progress", "id", i.ID, "percent", pct, "tag", tag, "summary", summary)
+}
+
+// trackBootstrapControl follows STATUS_CLIENT BOOTSTRAP events on the control
+// port. Any failure (socket not up yet, command error, connection lost) is
+// retried with backoff for as long as this process generation is alive, so an
+// instance is never silently left out of the pool; past the bootstrap timeout
+// the stall is logged as an error.
+func (i *Instance) trackBootstrapControl(gen uint32) {
+	deadline := time.Now().Add(bootstrapTimeout())
+	backoff := 500 * time.Millisecond
+	stuck := false
+
+	for {
+		err := i.followBootstrap(gen)
+		if err == nil || atomic.LoadUint32(&i.gen) != gen || !i.Running() {
+			if stuck && i.Bootstrapped() {
+				slog.Info("bootstrap tracking recovered", "id", i.ID)
+			}
+			return
+		}
+		if !stuck && time.Now().After(deadline) {
+			stuck = true
+			slog.Error("bootstrap tracking stuck — instance stays out of the pool until tor's control port answers",
+				"id", i.ID, "err", err)
+		} else {
+			slog.Debug("bootstrap tracking retry", "id", i.ID, "err", err, "in", backoff)
+		}
+
+		time.Sleep(backoff)
+		backoff = min(backoff*2, 30*time.Second)
+	}
+}
+
+// followBootstrap runs one control session until tor reports 100% (nil) or
+// the tracked process is replaced (nil). Anything else is an error to retry.
+func (i *Instance) followBootstrap(gen uint32) error {
+	conn, err := i.DialControl()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if err := conn.SetEvents("STATUS_CLIENT"); err != nil {
+		return fmt.Errorf("setevents: %w", err)
+	}
+	info, err := conn.GetInfo("status/bootstrap-phase")
+	if err != nil {
+		return fmt.Errorf("getinfo: %w", err)
+	}
+	i.applyStatus(gen, info["status/bootstrap-phase"])
+
+	for !i.Bootstrapped() {
+		ev, ok := <-conn.Events()
+		if atomic.LoadUint32(&i.gen) != gen {
+			return nil
+		}
+		if !ok {
+			return errors.New("control connection closed before bootstrap finished")
+		}
+		if ev.Type == "STATUS_CLIENT" && len(ev.Lines) > 0 {
+			i.applyStatus(gen, ev.Lines[0])
+		}
+	}
+	return nil
+}
+
+// applyStatus parses "[STATUS_CLIENT] NOTICE BOOTSTRAP PROGRESS=n TAG=t SUMMARY="s"".
+func (i *Instance) applyStatus(gen uint32, line string) {
+	if !strings.Contains(line, " BOOTSTRAP ") {
+		return
+	}
+	var pct = -1
+	var tag, summary string
+	for _, f := range strings.Fields(line) {
+		k, v, ok := strings.Cut(f, "=")
+		if !ok {
+			continue
+		}
+		switch k {
+		case "PROGRESS":
+			pct, _ = strconv.Atoi(v)
+		case "TAG":
+			tag = v
+		}
+	}
+	if _, s, ok := strings.Cut(line, `SUMMARY="`); ok {
+		summary, _, _ = strings.Cut(s, `"`)
+	}
+	if pct >= 0 {
+		i.setBootstrap(gen, pct, tag, summary)
+	}
+}
+
+// trackBootstrapLog scans tor's stdout (blind mode runs tor at notice level)
+// for "Bootstrapped N%" lines. Only warn/err lines are forwarded to our stdout,
+// matching the template's "Log warn stdout". Reads until EOF so tor never blocks.
+func (i *Instance) trackBootstrapLog(gen uint32, r io.ReadCloser) {
+	defer r.Close()
+
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 4096), 64<<10)
+	for sc.Scan() {
+		line := sc.Text()
+		if m := bootstrapLogRe.FindStringSubmatch(line); m != nil {
+			pct, _ := strconv.Atoi(m[1])
+			i.setBootstrap(gen, pct, m[2], m[3])
+			continue
+		}
+		if strings.Contains(line, "[warn]") || strings.Contains(line, "[err]") {
+			_, _ = os.Stdout.WriteString(line + "\n")
+		}
+	}
+}
+
+func bootstrapTimeout() time.Duration {
+	if cfg != nil && cfg.BootstrapTimeoutSeconds > 0 {
+		return time.Duration(cfg.BootstrapTimeoutSeconds) * time.Second
+	}
+	return 180 * time.Second
+}
@@ -3,13 +3,39 @@ package config
 import (
 	"fmt"
 	"log/slog"
+	"net"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"text/template"
 	"time"
+
+	"torgo/internal/control"
+	"torgo/internal/rules"
+)
+
+const (
+	dataRoot          = "/var/lib/tor-temp" // per-instance DataDirectory parent, wiped on close
+	controlSocketName = "control.sock"
+	cookieFileName    = "control_auth_cookie" // tor default under DataDirectory
+
+	RestartNever     = "never"
+	RestartOnFailure = "on-failure"
+	RestartAlways    = "always"
+
+	RebindOff    = "off"
+	RebindStrip  = "strip"
+	RebindRefuse = "refuse"
+
+	BlockNXDomain = "nxdomain"
+	BlockZero     = "zero" // 0.0.0.0 / ::
+
+	newnymMinInterval = 10 * time.Second // tor ignores NEWNYM more often than this
+	newnymBuildWait   = 60 * time.Second
 )
 
 type Config struct {
@@ -17,6 +43,39 @@ type Config struct {
 	SocksBindAddr string
 	SocksPort     string
 	DNSPort       string
+
+	// Listener specs (see listen.go); default to the single bind addr:port
+	SocksListeners []Listener
+	DNSBindAddr    string
+	DNSListeners   []Listener
+
+	// Optional Prometheus metrics listener, host:port (empty = disabled)
+	MetricsAddr string
+
+	// Optional admin API on a Unix socket in its own directory under /run,
+	// e.g. /run/torgo/admin.sock (empty = disabled). Callers need the bearer
+	// token and an allowed peer UID (SO_PEERCRED).
+	AdminSocket    string
+	AdminToken     string `json:"-"`
+	AdminAllowUIDs []int
+
+	// Optional per-tier SOCKS listeners (empty = disabled)
+	SocksStablePort   string
+	SocksParanoidPort string
+
+	// Optional HTTP CONNECT / absolute-URI proxy listener (empty = disabled)
+	HTTPProxyPort string
+
+	// Optional transparent (iptables REDIRECT) listener (empty = disabled)
+	TransProxyPort string
+
+	// Listener specs for the optional frontends: the *_LISTEN spec if set,
+	// else bind addr:port when the port is set, else none (disabled)
+	SocksStableListeners   []Listener
+	SocksParanoidListeners []Listener
+	HTTPListeners          []Listener
+	TransListeners         []Listener // TCP only (SO_ORIGINAL_DST)
+
 	BlindControl  bool
 
 	// Global limits
@@ -29,6 +88,36 @@ type Config struct {
 	DNSMaxConns        int
 	DNSMaxConnsPerInst int
 
+	// DNS cache (0 entries = disabled). TTLs are clamped to [Min, Max];
+	// NXDOMAIN/NODATA answers are kept for NegTTL. PerClient keys entries on
+	// the client address so one client can't probe another's lookups by timing.
+	DNSCacheSize      int
+	DNSCacheMinTTL    int
+	DNSCacheMaxTTL    int
+	DNSCacheNegTTL    int
+	DNSCachePerClient bool
+
+	// Encrypted DNS frontends (empty port = disabled). Without a cert/key
+	// pair a self-signed certificate is generated at startup.
+	DoHPort    string
+	DoHPath    string
+	DoTPort    string // RFC 7858 uses 853
+	DNSTLSCert string
+	DNSTLSKey  string
+
+	// DNS rebinding filter for private/loopback/link-local answers, off
+	// by default: RebindStrip drops those records, RebindRefuse answers REFUSED
+	DNSRebindFilter string
+
+	// Local blocklists (hosts or domain-list files), answered without tor
+	DNSBlocklists      []string
+	DNSBlockMode       string // BlockNXDomain or BlockZero
+	DNSBlockReloadSecs int
+
+	// DoT has its own cap (on top of DNSMaxConns) and idle timeout
+	DoTMaxConns int
+	DoTIdleSecs int
+
 	// Two-tier pool config
 	StableInstances             int
 	StableMaxConnsPerInstance   int
@@ -39,22 +128,64 @@ type Config struct {
 	ParanoidRotateSeconds       int
 	ParanoidTrafficPercent      int
 
+	// Hard rotation = full tor restart (new guard, re-bootstrap) instead of SIGNAL NEWNYM
+	StableHardRotate   bool
+	ParanoidHardRotate bool
+
 	SocksJitterMaxMs int
 	ChaffEnabled     bool
+
+	// Destination routing rules (TORGO_ROUTE_RULES_FILE + TORGO_ROUTE_RULES)
+	RouteRulesFile string
+	Routes         *rules.Set `json:"-"`
+
+	// Sticky sessions keyed by SOCKS credentials: idle TTL, refreshed on
+	// every use (0 = disabled, the default)
+	StickyTTLSeconds int
+	StickyKey        string // "user" or "userpass"
+
+	BootstrapTimeoutSeconds int
+
+	// Startup quorum: serve once this many instances are bootstrapped
+	MinReadyInstances int
+	MinReadyPercent   int
+
+	// Self-healing (health): never | on-failure | always
+	RestartPolicy         string
+	RestartBackoffMinSecs int
+	RestartBackoffMaxSecs int
+	RestartMaxPerWindow   int // 0 = no circuit breaker
+	RestartWindowSecs     int
 }
 
 type Instance struct {
 	ID        int
 	SocksPort int
 	DNSPort   int
-	DataDir   string
+	DataDir   string // fixed by NewInstance; never reassigned, so read without locks
 	cmd       *exec.Cmd
+
+	life    sync.Mutex // serializes Start/Close/Restart
+	stopped bool       // set by Close; Restart refuses to resurrect
+
+	mu         sync.Mutex
+	lastNewnym time.Time
+
+	gen     uint32 // bumped on every Start/Close; fences stale trackers
+	bootPct int32
+	bootTag string
+
+	running   uint32        // 1 while the child is alive (set by Start, cleared by supervisor/Close)
+	exited    chan struct{} // closed by the supervisor once the child is reaped
+	lastExit  ExitEvent
+	startedAt time.Time // guarded by mu
 }
 
 type TemplateData struct {
-	SOCKSPORT string
-	DNSPORT   string
-	DATADIR   string
+	SOCKSPORT   string
+	DNSPORT     string
+	DATADIR     string
+	CONTROLPORT string // unix socket path, empty = no ControlPort (blind)
 }
 
 var (
@@ -74,6 +205,12 @@ func Load() *Config {
 		SocksBindAddr: getEnv("COMMON_SOCKS_BIND_ADDR", "0.0.0.0"),
 		SocksPort:     getEnv("COMMON_SOCKS_PROXY_PORT", "9150"),
 		DNSPort:       getEnv("COMMON_DNS_PROXY_PORT", "5353"),
+
+		SocksStablePort:   os.Getenv("COMMON_SOCKS_STABLE_PORT"),
+		SocksParanoidPort: os.Getenv("COMMON_SOCKS_PARANOID_PORT"),
+		HTTPProxyPort:     os.Getenv("COMMON_HTTP_PROXY_PORT"),
+		TransProxyPort:    os.Getenv("COMMON_TRANS_PROXY_PORT"),
+
 		BlindControl:  os.Getenv("TORGO_BLIND_CONTROL") == "1",
 
 		MaxConnsPerInstance: getInt("TORGO_MAX_CONNS_PER_INSTANCE", 64, 4096),
@@ -82,10 +219,29 @@ func Load() *Config {
 		DNSMaxConns:        getInt("TORGO_DNS_MAX_CONNS", 256, 4096),
 		DNSMaxConnsPerInst: getInt("TORGO_DNS_MAX_PER_INST", 64, 1024),
 
+		DNSCacheSize:      getInt("TORGO_DNS_CACHE_SIZE", 0, 65536),
+		DNSCacheMinTTL:    getInt("TORGO_DNS_CACHE_MIN_TTL", 60, 86400),
+		DNSCacheMaxTTL:    getInt("TORGO_DNS_CACHE_MAX_TTL", 3600, 86400),
+		DNSCacheNegTTL:    getInt("TORGO_DNS_CACHE_NEG_TTL", 60, 86400),
+		DNSCachePerClient: os.Getenv("TORGO_DNS_CACHE_PER_CLIENT") == "1",
+
+		DoHPort:    os.Getenv("COMMON_DOH_PORT"),
+		DoHPath:    getEnv("TORGO_DOH_PATH", "/dns-query"),
+		DoTPort:    os.Getenv("COMMON_DOT_PORT"),
+		DNSTLSCert: os.Getenv("TORGO_DNS_TLS_CERT"),
+		DNSTLSKey:  os.Getenv("TORGO_DNS_TLS_KEY"),
+
+		DoTMaxConns: getInt("TORGO_DOT_MAX_CONNS", 128, 4096),
+		DoTIdleSecs: getInt("TORGO_DOT_IDLE_SECS", 30, 3600),
+
 		SocksJitterMaxMs: getInt("TORGO_SOCKS_JITTER_MS_MAX", 0, 5000),
 		ChaffEnabled:     os.Getenv("TORGO_ENABLE_CHAFF") == "1",
 	}
 
+	if c.DNSCacheMaxTTL < c.DNSCacheMinTTL {
+		c.DNSCacheMaxTTL = c.DNSCacheMinTTL
+	}
+
 	// Rotation Settings
 	c.RotateAfterConns = getInt("TORGO_ROTATE_CONNS", 64, 1_000_000_000)
 	c.RotateAfterSeconds = getInt("TORGO_ROTATE_SECS", 900, 315_360_000)
@@ -116,21 +272,174 @@ func Load() *Config {
 
 	c.ParanoidTrafficPercent = clamp(getInt("TORGO_PARANOID_TRAFFIC_PERCENT", 30, 100), 0, 100)
 
+	c.RouteRulesFile = os.Getenv("TORGO_ROUTE_RULES_FILE")
+	routes, err := loadRoutes(c.RouteRulesFile, os.Getenv("TORGO_ROUTE_RULES"))
+	if err != nil {
+		// Fail closed: a half-loaded rule set could silently drop a "reject"
+		slog.Error("routing rules invalid — aborting", "err", err)
+		os.Exit(1)
+	}
+	c.Routes = routes
+
+	c.SocksListeners, err = ParseListeners(os.Getenv("COMMON_SOCKS_LISTEN"),
+		Listener{Network: "tcp", Addr: net.JoinHostPort(c.SocksBindAddr, c.SocksPort)})
+	if err != nil {
+		slog.Error("socks listener spec invalid — aborting", "err", err)
+		os.Exit(1)
+	}
+	c.DNSBindAddr = getEnv("COMMON_DNS_BIND_ADDR", c.SocksBindAddr)
+	c.DNSListeners, err = ParseListeners(os.Getenv("COMMON_DNS_LISTEN"),
+		Listener{Network: "tcp", Addr: net.JoinHostPort(c.DNSBindAddr, c.DNSPort)})
+	if err != nil {
+		slog.Error("dns listener spec invalid — aborting", "err", err)
+		os.Exit(1)
+	}
+	for _, o := range []struct {
+		dst  *[]Listener
+		env  string
+		port string
+	}{
+		{&c.SocksStableListeners, "COMMON_SOCKS_STABLE_LISTEN", c.SocksStablePort},
+		{&c.SocksParanoidListeners, "COMMON_SOCKS_PARANOID_LISTEN", c.SocksParanoidPort},
+		{&c.HTTPListeners, "COMMON_HTTP_PROXY_LISTEN", c.HTTPProxyPort},
+		{&c.TransListeners, "COMMON_TRANS_PROXY_LISTEN", c.TransProxyPort},
+	} {
+		var def []Listener
+		if o.port != "" {
+			def = []Listener{{Network: "tcp", Addr: net.JoinHostPort(c.SocksBindAddr, o.port)}}
+		}
+		if *o.dst, err = ParseListeners(os.Getenv(o.env), def...); err != nil {
+			slog.Error("listener spec invalid — aborting", "env", o.env, "err", err)
+			os.Exit(1)
+		}
+	}
+	for _, l := range c.TransListeners {
+		if l.Network != "tcp" {
+			slog.Error("transparent proxy needs TCP listeners — aborting", "listener", l)
+			os.Exit(1)
+		}
+	}
+
+	c.MetricsAddr = os.Getenv("TORGO_METRICS_ADDR")
+
+	c.AdminSocket = os.Getenv("TORGO_ADMIN_SOCKET")
+	if c.AdminSocket != "" {
+		clean := filepath.Clean(c.AdminSocket)
+		if dir := filepath.Dir(clean); !strings.HasPrefix(dir, "/run/") {
+			slog.Error("TORGO_ADMIN_SOCKET must be inside a directory under /run — admin api disabled",
+				"path", c.AdminSocket)
+			clean = ""
+		}
+		c.AdminSocket = clean
+	}
+	c.AdminToken, err = loadAdminToken(os.Getenv("TORGO_ADMIN_TOKEN_FILE"))
+	if err != nil {
+		slog.Error("admin token unreadable — admin api disabled", "err", err)
+		c.AdminSocket = ""
+	}
+	c.AdminAllowUIDs = []int{os.Geteuid()}
+	for _, f := range strings.Split(os.Getenv("TORGO_ADMIN_UIDS"), ",") {
+		if f = strings.TrimSpace(f); f == "" {
+			continue
+		}
+		if uid, err := strconv.Atoi(f); err == nil && uid >= 0 {
+			c.AdminAllowUIDs = append(c.AdminAllowUIDs, uid)
+		}
+	}
+
+	c.DNSRebindFilter = getEnv("TORGO_DNS_REBIND_FILTER", RebindOff)
+	switch c.DNSRebindFilter {
+	case RebindOff, RebindStrip, RebindRefuse:
+	default:
+		slog.Warn("unknown TORGO_DNS_REBIND_FILTER — using off", "value", c.DNSRebindFilter)
+		c.DNSRebindFilter = RebindOff
+	}
+
+	for _, p := range strings.Split(os.Getenv("TORGO_DNS_BLOCKLISTS"), ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			c.DNSBlocklists = append(c.DNSBlocklists, p)
+		}
+	}
+	c.DNSBlockMode = getEnv("TORGO_DNS_BLOCK_MODE", BlockNXDomain)
+	if c.DNSBlockMode != BlockNXDomain && c.DNSBlockMode != BlockZero {
+		slog.Warn("unknown TORGO_DNS_BLOCK_MODE — using nxdomain", "value", c.DNSBlockMode)
+		c.DNSBlockMode = BlockNXDomain
+	}
+	c.DNSBlockReloadSecs = getInt("TORGO_DNS_BLOCKLIST_RELOAD_SECS", 60, 86400)
+
+	c.StickyTTLSeconds = getInt("TORGO_STICKY_TTL_SECS", 0, 86400)
+	c.StickyKey = getEnv("TORGO_STICKY_KEY", "user")
+	if c.StickyKey != "user" && c.StickyKey != "userpass" {
+		slog.Warn("unknown TORGO_STICKY_KEY — using user", "value", c.StickyKey)
+		c.StickyKey = "user"
+	}
+
+	c.BootstrapTimeoutSeconds = getInt("TORGO_BOOTSTRAP_TIMEOUT_SECS", 180, 3600)
+
+	// Quorum: default is "all instances" unless an absolute count is given
+	c.MinReadyInstances = getInt("TORGO_MIN_READY_INSTANCES", 0, n)
+	defReadyPct := 100
+	if c.MinReadyInstances > 0 { defReadyPct = 0 }
+	c.MinReadyPercent = getInt("TORGO_MIN_READY_PERCENT", defReadyPct, 100)
+
+	c.RestartPolicy = getEnv("TORGO_RESTART_POLICY", RestartNever)
+	switch c.RestartPolicy {
+	case RestartNever, RestartOnFailure, RestartAlways:
+	default:
+		slog.Warn("unknown TORGO_RESTART_POLICY — using never", "value", c.RestartPolicy)
+		c.RestartPolicy = RestartNever
+	}
+	c.RestartBackoffMinSecs = max(getInt("TORGO_RESTART_BACKOFF_MIN_SECS", 5, 3600), 1)
+	c.RestartBackoffMaxSecs = max(getInt("TORGO_RESTART_BACKOFF_MAX_SECS", 300, 86400), c.RestartBackoffMinSecs)
+	c.RestartMaxPerWindow = getInt("TORGO_RESTART_MAX", 5, 1000)
+	c.RestartWindowSecs = getInt("TORGO_RESTART_WINDOW_SECS", 600, 86400)
+
+	c.StableHardRotate = os.Getenv("TORGO_STABLE_HARD_ROTATE") == "1"
+	c.ParanoidHardRotate = os.Getenv("TORGO_PARANOID_HARD_ROTATE") == "1"
+
 	cfg = c
 	
 	slog.Info("zero-trust config loaded",
 		"instances", c.Instances,
 		"blind", c.BlindControl,
 		"chaffEnabled", c.ChaffEnabled,
+		"restartPolicy", c.RestartPolicy,
+		"routeRules", c.Routes.Len(),
 		"mode", "direct_tor_hardened",
 	)
 
 	return c
 }
 
+// ReadyQuorum is how many bootstrapped instances are needed before serving:
+// the larger of TORGO_MIN_READY_INSTANCES and TORGO_MIN_READY_PERCENT of the pool.
+func (c *Config) ReadyQuorum() int {
+	byPct := (c.Instances*c.MinReadyPercent + 99) / 100
+	return clamp(max(c.MinReadyInstances, byPct), 1, max(c.Instances, 1))
+}
+
+// NewInstance returns a stopped instance with its data directory fixed for
+// its whole life (restarts wipe and reuse it).
+func NewInstance(id, socksPort, dnsPort int) *Instance {
+	return &Instance{
+		ID:        id,
+		SocksPort: socksPort,
+		DNSPort:   dnsPort,
+		DataDir:   filepath.Join(dataRoot, fmt.Sprintf("i%d", id)),
+	}
+}
+
 func (i *Instance) Start() error {
-	i.DataDir = fmt.Sprintf("/var/lib/tor-temp/i%d", i.ID)
+	i.life.Lock()
+	defer i.life.Unlock()
+	i.stopped = false
+	return i.start()
+}
 
+func (i *Instance) start() error {
+	if i.DataDir == "" {
+		return fmt.Errorf("instance %d: no data dir (use NewInstance)", i.ID)
+	}
 	if err := os.MkdirAll(i.DataDir, 0o700); err != nil {
 		return fmt.Errorf("mkdir data dir failed: %w", err)
 	}
@@ -151,15 +460,21 @@ func (i *Instance) Start() error {
 		SOCKSPORT:   "127.0.0.1:" + strconv.Itoa(i.SocksPort),
 		DNSPORT:     "127.0.0.1:" + strconv.Itoa(i.DNSPort),
 		DATADIR:     i.DataDir,
+		CONTROLPORT: i.ControlPath(),
 	}
 
 	if err := globalTmpl.Execute(&b, data); err != nil {
 		return fmt.Errorf("template exec failed: %w", err)
 	}
 
-	cmd := exec.Command("tor", "-f", "/dev/stdin")
+	args := []string{"-f", "/dev/stdin"}
+	if !controlEnabled() {
+		// Blind: bootstrap progress only exists in notice-level logs
+		args = append(args, "--Log", "notice stdout")
+	}
+
+	cmd := exec.Command("tor", args...)
 	cmd.Stdin = strings.NewReader(b.String())
-	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
 	cmd.Dir = i.DataDir
 	
@@ -169,37 +484,237 @@ func (i *Instance) Start() error {
 		"PATH=/usr/bin:/bin", 
 	}
 
+	var logR *os.File
+	if controlEnabled() {
+		cmd.Stdout = os.Stdout
+	} else {
+		r, w, err := os.Pipe()
+		if err != nil {
+			return fmt.Errorf("stdout pipe failed: %w", err)
+		}
+		logR = r
+		cmd.Stdout = w
+		defer w.Close() // child holds its own copy
+	}
+
+	gen := atomic.AddUint32(&i.gen, 1)
+	i.resetBootstrap()
+
 	i.cmd = cmd
+	i.exited = nil
 	if err := cmd.Start(); err != nil {
+		if logR != nil {
+			_ = logR.Close()
+		}
 		slog.Error("tor start failed", "id", i.ID, "err", err)
 		return err
 	}
-	slog.Info("tor instance started", "id", i.ID)
+
+	// Supervisor: the only place that ever calls cmd.Wait()
+	exited := make(chan struct{})
+	i.exited = exited
+	i.mu.Lock()
+	i.startedAt = time.Now()
+	i.mu.Unlock()
+	atomic.StoreUint32(&i.running, 1)
+	go i.supervise(gen, cmd, exited)
+
+	if logR != nil {
+		go i.trackBootstrapLog(gen, logR)
+	} else {
+		go i.trackBootstrapControl(gen)
+	}
+	slog.Info("tor instance started", "id", i.ID, "control", i.ControlPath() != "")
 	return nil
 }
 
 func (i *Instance) Close() {
-	if i.cmd != nil && i.cmd.Process != nil {
+	i.life.Lock()
+	defer i.life.Unlock()
+	i.stopped = true
+	i.close()
+}
+
+func (i *Instance) close() {
+	atomic.AddUint32(&i.gen, 1)
+	atomic.StoreUint32(&i.running, 0)
+	i.resetBootstrap()
+	if i.cmd != nil && i.cmd.Process != nil && i.exited != nil {
 		_ = i.cmd.Process.Signal(os.Interrupt)
-		done := make(chan error, 1)
-		go func() { done <- i.cmd.Wait() }()
 		select {
-		case <-done:
+		case <-i.exited:
 		case <-time.After(2 * time.Second):
 			_ = i.cmd.Process.Kill()
+			<-i.exited // supervisor reaps it
 		}
 	}
-	if i.DataDir != "" && strings.HasPrefix(i.DataDir, "/var/lib/tor-temp") {
+	if i.DataDir != "" && strings.HasPrefix(i.DataDir, dataRoot) {
 		_ = os.RemoveAll(i.DataDir)
 	}
 }
 
+// Restart replaces the tor process. Safe to call concurrently from rotation
+// and health; refused once the instance has been Closed for shutdown.
 func (i *Instance) Restart() error {
-	i.Close()
-	return i.Start()
+	i.life.Lock()
+	defer i.life.Unlock()
+	if i.stopped {
+		return fmt.Errorf("instance %d: closed", i.ID)
+	}
+	i.close()
+	return i.start()
+}
+
+// ControlPath is the Unix ControlPort socket inside DataDir ("" when blind).
+func (i *Instance) ControlPath() string {
+	if !controlEnabled() || i.DataDir == "" {
+		return ""
+	}
+	return filepath.Join(i.DataDir, controlSocketName)
+}
+
+// CookiePath is where tor writes the control auth cookie ("" when blind).
+func (i *Instance) CookiePath() string {
+	if !controlEnabled() || i.DataDir == "" {
+		return ""
+	}
+	return filepath.Join(i.DataDir, cookieFileName)
+}
+
+// DialControl opens and authenticates a control connection to this instance.
+// Callers own the returned connection and must Close it.
+func (i *Instance) DialControl() (*control.Conn, error) {
+	path := i.ControlPath()
+	if path == "" {
+		return nil, fmt.Errorf("instance %d: control port disabled (TORGO_BLIND_CONTROL=1)", i.ID)
+	}
+	c, err := control.Dial(path, 2*time.Second)
+	if err != nil {
+		return nil, fmt.Errorf("instance %d: control dial: %w", i.ID, err)
+	}
+	if err := c.Authenticate(i.CookiePath()); err != nil {
+		_ = c.Close()
+		return nil, fmt.Errorf("instance %d: %w", i.ID, err)
+	}
+	return c, nil
+}
+
+// NewIdentity rotates circuits without restarting tor: SIGNAL NEWNYM, then
+// wait until tor reports a circuit BUILT that did not exist before the
+// signal. Guard and consensus survive.
+// Calls closer together than tor's NEWNYM rate limit are delayed, not dropped.
+func (i *Instance) NewIdentity() error {
+	i.mu.Lock()
+	wait := time.Until(i.lastNewnym.Add(newnymMinInterval))
+	i.mu.Unlock()
+	if wait > 0 {
+		time.Sleep(wait)
+	}
+
+	c, err := i.DialControl()
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	// Subscribe first so no BUILT event can slip between signal and wait
+	if err := c.SetEvents("CIRC"); err != nil {
+		return fmt.Errorf("instance %d: %w", i.ID, err)
+	}
+	// Circuits that exist (or are being built) now belong to the old
+	// identity; their BUILT events must not count as the new one
+	old, err := circuitIDs(c)
+	if err != nil {
+		return fmt.Errorf("instance %d: %w", i.ID, err)
+	}
+	if err := c.Signal("NEWNYM"); err != nil {
+		return fmt.Errorf("instance %d: %w", i.ID, err)
+	}
+
+	i.mu.Lock()
+	i.lastNewnym = time.Now()
+	i.mu.Unlock()
+
+	// Nudge tor to build one now instead of waiting for the next stream
+	if err := c.ExtendCircuit(); err != nil {
+		slog.Debug("extendcircuit after newnym failed", "id", i.ID, "err", err)
+	}
+
+	timeout := time.NewTimer(newnymBuildWait)
+	defer timeout.Stop()
+
+	for {
+		select {
+		case ev, ok := <-c.Events():
+			if !ok {
+				return fmt.Errorf("instance %d: control closed while waiting for circuit", i.ID)
+			}
+			// CIRC <id> <status> ...
+			f := strings.Fields(ev.Lines[0])
+			if ev.Type != "CIRC" || len(f) < 3 || f[2] != "BUILT" {
+				continue
+			}
+			if _, stale := old[f[1]]; !stale {
+				return nil
+			}
+		case <-timeout.C:
+			return fmt.Errorf("instance %d: no circuit built within %s of NEWNYM", i.ID, newnymBuildWait)
+		}
+	}
 }
 
-func (i *Instance) CookiePath() string { return "" }
+// circuitIDs returns the IDs of every circuit tor currently knows about
+// (GETINFO circuit-status: one "<id> <status> ..." line per circuit).
+func circuitIDs(c *control.Conn) (map[string]struct{}, error) {
+	info, err := c.GetInfo("circuit-status")
+	if err != nil {
+		return nil, err
+	}
+	ids := make(map[string]struct{})
+	for _, line := range strings.Split(info["circuit-status"], "\n") {
+		if f := strings.Fields(line); len(f) > 0 {
+			ids[f[0]] = struct{}{}
+		}
+	}
+	return ids, nil
+}
+
+// controlEnabled reports whether instances get a ControlPort (TORGO_BLIND_CONTROL!=1).
+func controlEnabled() bool {
+	return cfg != nil && !cfg.BlindControl
+}
+
+// loadAdminToken prefers a token file; TORGO_ADMIN_TOKEN is accepted but
+// scrubbed from the environment right away (tor children never see it).
+func loadAdminToken(path string) (string, error) {
+	tok := os.Getenv("TORGO_ADMIN_TOKEN")
+	os.Unsetenv("TORGO_ADMIN_TOKEN")
+	if path != "" {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("read %s: %w", path, err)
+		}
+		tok = strings.TrimSpace(string(b))
+	}
+	return tok, nil
+}
+
+// loadRoutes reads rule lines from path (if set) followed by the
+// ';'-separated inline rules, so inline entries match after file entries.
+func loadRoutes(path, inline string) (*rules.Set, error) {
+	var lines []string
+	if path != "" {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("read %s: %w", path, err)
+		}
+		lines = strings.Split(string(b), "\n")
+	}
+	if inline != "" {
+		lines = append(lines, strings.Split(inline, ";")...)
+	}
+	return rules.Parse(lines)
+}
 
 func getEnv(key, def string) string {
 	if s := os.Getenv(key); s != "" { return s }
@@ -0,0 +1,142 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Listener is one bind point from a listener spec.
+//
+// Spec syntax (COMMON_SOCKS_LISTEN, COMMON_DNS_LISTEN and the optional
+// frontends' COMMON_*_LISTEN): comma-separated entries, each an address with
+// optional ";max=N" per-listener cap:
+//
+//	127.0.0.1:9150, [::1]:9150;max=64, unix:/run/torgo/socks.sock
+type Listener struct {
+	Network  string // "tcp" or "unix"
+	Addr     string
+	MaxConns int // 0 = only the service-wide caps apply
+}
+
+func (l Listener) String() string {
+	if l.Network == "unix" {
+		return "unix:" + l.Addr
+	}
+	return l.Addr
+}
+
+// Listen opens the stream listener. A stale Unix socket left behind by a
+// previous run is removed first; the new one is group read/writable only.
+func (l Listener) Listen() (net.Listener, error) {
+	if l.Network != "unix" {
+		return net.Listen(l.Network, l.Addr)
+	}
+	if fi, err := os.Lstat(l.Addr); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		_ = os.Remove(l.Addr)
+	}
+	ln, err := net.Listen("unix", l.Addr)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(l.Addr, 0o660); err != nil {
+		ln.Close()
+		return nil, fmt.Errorf("chmod %s: %w", l.Addr, err)
+	}
+	return ln, nil
+}
+
+// ParseListeners parses a listener spec; an empty spec yields def. The same
+// address listed twice is an error (the second bind would fail anyway).
+func ParseListeners(spec string, def ...Listener) ([]Listener, error) {
+	if strings.TrimSpace(spec) == "" {
+		return def, nil
+	}
+
+	var out []Listener
+	seen := make(map[string]string) // normalized address -> entry
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		addr, opts, _ := strings.Cut(entry, ";")
+
+		var l Listener
+		var key string
+		if path, ok := strings.CutPrefix(addr, "unix:"); ok {
+			if !strings.HasPrefix(path, "/") {
+				return nil, fmt.Errorf("listener %q: unix socket path must be absolute", entry)
+			}
+			key = "unix:" + filepath.Clean(path)
+			l = Listener{Network: "unix", Addr: path}
+		} else {
+			host, port, err := net.SplitHostPort(addr)
+			if err != nil {
+				return nil, fmt.Errorf("listener %q: %w", entry, err)
+			}
+			p, err := strconv.Atoi(port)
+			if err != nil || p <= 0 || p > 65535 {
+				return nil, fmt.Errorf("listener %q: bad port", entry)
+			}
+			l = Listener{Network: "tcp", Addr: addr}
+			if ip, err := netip.ParseAddr(host); err == nil {
+				host = ip.String()
+			}
+			key = net.JoinHostPort(strings.ToLower(host), strconv.Itoa(p))
+		}
+		if prev, dup := seen[key]; dup {
+			return nil, fmt.Errorf("listener %q: same address as %q", entry, prev)
+		}
+		seen[key] = entry
+
+		for _, opt := range strings.Split(opts, ";") {
+			opt = strings.TrimSpace(opt)
+			if opt == "" {
+				continue
+			}
+			v, ok := strings.CutPrefix(opt, "max=")
+			if !ok {
+				return nil, fmt.Errorf("listener %q: unknown option %q", entry, opt)
+			}
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 || n > 65535 {
+				return nil, fmt.Errorf("listener %q: bad max %q", entry, v)
+			}
+			l.MaxConns = n
+		}
+		out = append(out, l)
+	}
+	if len(out) == 0 {
+		return nil, errors.New("listener spec has no entries")
+	}
+	return out, nil
+}
+
+// LocalDial picks the address torgo's own helpers (selfcheck, chaff) should
+// dial to reach a service: a TCP listener (wildcards mapped to loopback),
+// else the first Unix socket.
+func LocalDial(ls []Listener) (network, addr string) {
+	for _, l := range ls {
+		if l.Network != "tcp" {
+			continue
+		}
+		host, port, _ := net.SplitHostPort(l.Addr)
+		switch host {
+		case "", "0.0.0.0":
+			host = "127.0.0.1"
+		case "::":
+			host = "::1"
+		}
+		return "tcp", net.JoinHostPort(host, port)
+	}
+	if len(ls) > 0 {
+		return ls[0].Network, ls[0].Addr
+	}
+	return "tcp", ""
+}
@@ -0,0 +1,91 @@
+package config
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestParseListeners(t *testing.T) {
+	def := Listener{Network: "tcp", Addr: "127.0.0.1:9150"}
+
+	tests := []struct {
+		name    string
+		spec    string
+		wantErr string // substring; "" = must parse
+		want    []Listener
+	}{
+		{name: "empty spec yields default", spec: "", want: []Listener{def}},
+		{name: "blank spec yields default", spec: "  \t ", want: []Listener{def}},
+		{name: "single tcp", spec: "0.0.0.0:9050", want: []Listener{{Network: "tcp", Addr: "0.0.0.0:9050"}}},
+		{name: "mixed list", spec: "127.0.0.1:9150, [::1]:9150;max=64 ,unix:/run/torgo/socks.sock;max=0", want: []Listener{
+			{Network: "tcp", Addr: "127.0.0.1:9150"},
+			{Network: "tcp", Addr: "[::1]:9150", MaxConns: 64},
+			{Network: "unix", Addr: "/run/torgo/socks.sock"},
+		}},
+		{name: "hostname", spec: "localhost:9150", want: []Listener{{Network: "tcp", Addr: "localhost:9150"}}},
+		{name: "empty entries skipped", spec: ",127.0.0.1:1,,", want: []Listener{{Network: "tcp", Addr: "127.0.0.1:1"}}},
+		{name: "max upper bound", spec: "127.0.0.1:1;max=65535", want: []Listener{{Network: "tcp", Addr: "127.0.0.1:1", MaxConns: 65535}}},
+		{name: "same port, different hosts", spec: "127.0.0.1:9150,[::1]:9150", want: []Listener{
+			{Network: "tcp", Addr: "127.0.0.1:9150"},
+			{Network: "tcp", Addr: "[::1]:9150"},
+		}},
+
+		{name: "only separators", spec: " , ,", wantErr: "no entries"},
+		{name: "missing port", spec: "127.0.0.1", wantErr: "missing port"},
+		{name: "port zero", spec: "127.0.0.1:0", wantErr: "bad port"},
+		{name: "port too big", spec: "127.0.0.1:65536", wantErr: "bad port"},
+		{name: "named port", spec: "127.0.0.1:socks", wantErr: "bad port"},
+		{name: "relative unix path", spec: "unix:torgo.sock", wantErr: "must be absolute"},
+		{name: "max not a number", spec: "127.0.0.1:1;max=lots", wantErr: `bad max "lots"`},
+		{name: "max negative", spec: "127.0.0.1:1;max=-1", wantErr: "bad max"},
+		{name: "max too big", spec: "127.0.0.1:1;max=65536", wantErr: "bad max"},
+		{name: "max empty", spec: "127.0.0.1:1;max=", wantErr: "bad max"},
+		{name: "unknown option", spec: "127.0.0.1:1;backlog=5", wantErr: `unknown option "backlog=5"`},
+
+		{name: "duplicate tcp", spec: "127.0.0.1:9150,127.0.0.1:9150;max=4", wantErr: "same address"},
+		{name: "duplicate with leading zero port", spec: "127.0.0.1:9150,127.0.0.1:09150", wantErr: "same address"},
+		{name: "duplicate ipv6 spellings", spec: "[::1]:53,[0:0::1]:53", wantErr: "same address"},
+		{name: "duplicate hostname case", spec: "localhost:53,LOCALHOST:53", wantErr: "same address"},
+		{name: "duplicate unix", spec: "unix:/run/torgo/s.sock,unix:/run/torgo//s.sock", wantErr: "same address"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseListeners(tt.spec, def)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err = %v, want it to contain %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLocalDial(t *testing.T) {
+	tests := []struct {
+		ls      []Listener
+		network string
+		addr    string
+	}{
+		{nil, "tcp", ""},
+		{[]Listener{{Network: "tcp", Addr: "0.0.0.0:9150"}}, "tcp", "127.0.0.1:9150"},
+		{[]Listener{{Network: "tcp", Addr: "[::]:9150"}}, "tcp", "[::1]:9150"},
+		{[]Listener{{Network: "tcp", Addr: ":9150"}}, "tcp", "127.0.0.1:9150"},
+		{[]Listener{{Network: "unix", Addr: "/run/torgo/s.sock"}, {Network: "tcp", Addr: "10.0.0.1:9150"}}, "tcp", "10.0.0.1:9150"},
+		{[]Listener{{Network: "unix", Addr: "/run/torgo/s.sock"}}, "unix", "/run/torgo/s.sock"},
+	}
+	for _, tt := range tests {
+		network, addr := LocalDial(tt.ls)
+		if network != tt.network || addr != tt.addr {
+			t.Errorf("LocalDial(%v) = %s %s, want %s %s", tt.ls, network, addr, tt.network, tt.addr)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package config
+
+import (
+	"log/slog"
+	"os/exec"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// ExitEvent describes a tor process that has just been reaped.
+type ExitEvent struct {
+	ID       int
+	Code     int    // -1 when terminated by a signal
+	Signal   string // empty unless terminated by a signal
+	Expected bool   // true when torgo stopped it itself (Close/Restart)
+	At       time.Time
+}
+
+var (
+	exitSubsMu sync.Mutex
+	exitSubs   []chan ExitEvent
+)
+
+// SubscribeExits returns a channel that receives every instance exit.
+// Delivery is best-effort: a subscriber that falls behind loses events
+// rather than stalling the supervisor.
+func SubscribeExits() <-chan ExitEvent {
+	ch := make(chan ExitEvent, 32)
+	exitSubsMu.Lock()
+	exitSubs = append(exitSubs, ch)
+	exitSubsMu.Unlock()
+	return ch
+}
+
+func publishExit(ev ExitEvent) {
+	exitSubsMu.Lock()
+	defer exitSubsMu.Unlock()
+	for _, ch := range exitSubs {
+		select {
+		case ch <- ev:
+		default:
+		}
+	}
+}
+
+// Running reports whether the tor child process is alive.
+func (i *Instance) Running() bool {
+	return atomic.LoadUint32(&i.running) == 1
+}
+
+// Generation changes every time the tor process is started or stopped, so
+// anything tied to one process (circuits, guard, sticky sessions) can tell
+// that it has been replaced.
+func (i *Instance) Generation() uint32 {
+	return atomic.LoadUint32(&i.gen)
+}
+
+// Ready reports whether the instance can take traffic: alive and bootstrapped.
+func (i *Instance) Ready() bool {
+	return i.Running() && i.Bootstrapped()
+}
+
+// StartedAt returns when the current (or last) tor process was started.
+func (i *Instance) StartedAt() time.Time {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return i.startedAt
+}
+
+// LastExit returns how the previous tor process ended, if one has.
+func (i *Instance) LastExit() (ExitEvent, bool) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return i.lastExit, !i.lastExit.At.IsZero()
+}
+
+// supervise reaps the child as soon as it exits (no zombies), records the
+// exit status and publishes it. An exit is "expected" when Close bumped the
+// generation before the process went away.
+func (i *Instance) supervise(gen uint32, cmd *exec.Cmd, exited chan struct{}) {
+	err := cmd.Wait()
+
+	ev := ExitEvent{ID: i.ID, Code: -1, At: time.Now()}
+	if ps := cmd.ProcessState; ps != nil {
+		ev.Code = ps.ExitCode()
+		if ws, ok := ps.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+			ev.Signal = ws.Signal().String()
+		}
+	}
+	ev.Expected = atomic.LoadUint32(&i.gen) != gen
+
+	i.mu.Lock()
+	i.lastExit = ev
+	i.mu.Unlock()
+
+	if !ev.Expected {
+		atomic.StoreUint32(&i.running, 0)
+		i.resetBootstrap()
+		slog.Error("tor instance exited unexpectedly",
+			"id", i.ID,
+			"code", ev.Code,
+			"signal", ev.Signal,
+			"err", err,
+		)
+	}
+	close(exited)
+	publishExit(ev)
+}
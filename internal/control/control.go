@@ -0,0 +1,321 @@
+// internal/control/control.go — TOR CONTROL PORT CLIENT (UNIX SOCKET + SAFECOOKIE)
+package control
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// SAFECOOKIE HMAC keys (control-spec §3.24)
+	serverHashKey = "Tor safe cookie authentication server-to-controller hash"
+	clientHashKey = "Tor safe cookie authentication controller-to-server hash"
+
+	cookieLen   = 32
+	maxLineLen  = 64 << 10
+	cmdTimeout  = 10 * time.Second
+	eventBuffer = 64
+)
+
+var ErrClosed = errors.New("control: connection closed")
+
+// Reply is one complete (possibly multi-line) reply from tor.
+// Data blocks ("250+key=") are folded into a single line joined by '\n'.
+type Reply struct {
+	Status int
+	Lines  []string
+}
+
+// Event is an asynchronous 650 reply. Type is the first word of the first line.
+type Event struct {
+	Type  string
+	Lines []string
+}
+
+// Conn is an authenticated-or-not control connection. Commands are serialized;
+// async events are delivered on Events() and dropped if nobody reads them.
+// A command that times out closes the connection, since replies can no
+// longer be matched to commands.
+type Conn struct {
+	conn    net.Conn
+	r       *bufio.Reader
+	mu      sync.Mutex
+	replies chan *Reply // unbuffered: a reply is only taken by a waiting command
+	events  chan Event
+	done    chan struct{}
+	quit    chan struct{}
+	once    sync.Once
+	err     error
+}
+
+// Dial connects to a tor ControlPort bound to a Unix socket.
+func Dial(path string, timeout time.Duration) (*Conn, error) {
+	nc, err := net.DialTimeout("unix", path, timeout)
+	if err != nil {
+		return nil, err
+	}
+	c := &Conn{
+		conn:    nc,
+		r:       bufio.NewReaderSize(nc, 4096),
+		replies: make(chan *Reply),
+		events:  make(chan Event, eventBuffer),
+		done:    make(chan struct{}),
+		quit:    make(chan struct{}),
+	}
+	go c.readLoop()
+	return c, nil
+}
+
+func (c *Conn) Close() error {
+	var err error
+	c.once.Do(func() {
+		close(c.quit)
+		err = c.conn.Close()
+	})
+	return err
+}
+
+// Events returns the channel async events are delivered on.
+// It is closed when the connection dies.
+func (c *Conn) Events() <-chan Event { return c.events }
+
+// Done is closed once the read loop exits (tor went away or Close was called).
+func (c *Conn) Done() <-chan struct{} { return c.done }
+
+// Authenticate performs SAFECOOKIE authentication using the cookie at cookiePath.
+// The cookie itself never crosses the socket and is wiped from memory afterwards.
+func (c *Conn) Authenticate(cookiePath string) error {
+	cookie, err := os.ReadFile(cookiePath)
+	if err != nil {
+		return fmt.Errorf("control: read cookie: %w", err)
+	}
+	defer wipe(cookie)
+	if len(cookie) != cookieLen {
+		return fmt.Errorf("control: bad cookie length %d", len(cookie))
+	}
+
+	clientNonce := make([]byte, 32)
+	if _, err := rand.Read(clientNonce); err != nil {
+		return fmt.Errorf("control: nonce: %w", err)
+	}
+
+	rep, err := c.command("AUTHCHALLENGE SAFECOOKIE " + hex.EncodeToString(clientNonce))
+	if err != nil {
+		return err
+	}
+
+	var serverHash, serverNonce []byte
+	for _, field := range strings.Fields(rep.Lines[0]) {
+		k, v, ok := strings.Cut(field, "=")
+		if !ok {
+			continue
+		}
+		switch k {
+		case "SERVERHASH":
+			serverHash, _ = hex.DecodeString(v)
+		case "SERVERNONCE":
+			serverNonce, _ = hex.DecodeString(v)
+		}
+	}
+	if len(serverHash) == 0 || len(serverNonce) == 0 {
+		return errors.New("control: malformed AUTHCHALLENGE reply")
+	}
+
+	if !hmac.Equal(serverHash, safeCookieHash(serverHashKey, cookie, clientNonce, serverNonce)) {
+		return errors.New("control: server hash mismatch (wrong cookie or impostor socket)")
+	}
+
+	clientHash := safeCookieHash(clientHashKey, cookie, clientNonce, serverNonce)
+	_, err = c.command("AUTHENTICATE " + hex.EncodeToString(clientHash))
+	return err
+}
+
+// GetInfo runs GETINFO for the given keys and returns key → value.
+func (c *Conn) GetInfo(keys ...string) (map[string]string, error) {
+	rep, err := c.command("GETINFO " + strings.Join(keys, " "))
+	if err != nil {
+		return nil, err
+	}
+	out := make(map[string]string, len(keys))
+	for _, line := range rep.Lines {
+		k, v, ok := strings.Cut(line, "=")
+		if !ok {
+			continue // trailing "OK"
+		}
+		out[k] = strings.TrimPrefix(v, "\n")
+	}
+	return out, nil
+}
+
+// SetEvents replaces the set of async events tor sends on this connection.
+// Calling it with no arguments unsubscribes from everything.
+func (c *Conn) SetEvents(events ...string) error {
+	_, err := c.command(strings.TrimSpace("SETEVENTS " + strings.Join(events, " ")))
+	return err
+}
+
+// Signal sends SIGNAL <name> (NEWNYM, RELOAD, HEARTBEAT, ...).
+func (c *Conn) Signal(name string) error {
+	_, err := c.command("SIGNAL " + name)
+	return err
+}
+
+// ExtendCircuit asks tor to build a new general-purpose circuit on a path of
+// its own choosing (EXTENDCIRCUIT 0).
+func (c *Conn) ExtendCircuit() error {
+	_, err := c.command("EXTENDCIRCUIT 0")
+	return err
+}
+
+func (c *Conn) command(line string) (*Reply, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	_ = c.conn.SetWriteDeadline(time.Now().Add(cmdTimeout))
+	if _, err := c.conn.Write([]byte(line + "\r\n")); err != nil {
+		return nil, err
+	}
+
+	timer := time.NewTimer(cmdTimeout)
+	defer timer.Stop()
+
+	select {
+	case rep := <-c.replies:
+		if rep.Status != 250 {
+			msg := ""
+			if len(rep.Lines) > 0 {
+				msg = rep.Lines[0]
+			}
+			return nil, fmt.Errorf("control: %d %s", rep.Status, msg)
+		}
+		return rep, nil
+	case <-c.done:
+		if c.err != nil {
+			return nil, c.err
+		}
+		return nil, ErrClosed
+	case <-timer.C:
+		// tor answers strictly in order; a late reply would be handed to the
+		// next command, so the connection is unusable from here on
+		_ = c.Close()
+		return nil, fmt.Errorf("control: %q timed out", strings.Fields(line)[0])
+	}
+}
+
+func (c *Conn) readLoop() {
+	defer close(c.done)
+	defer close(c.events)
+
+	for {
+		rep, err := readReply(c.r)
+		if err != nil {
+			c.err = err
+			return
+		}
+
+		if rep.Status/100 == 6 {
+			ev := Event{Lines: rep.Lines}
+			if len(rep.Lines) > 0 {
+				ev.Type, _, _ = strings.Cut(rep.Lines[0], " ")
+			}
+			select {
+			case c.events <- ev:
+			default: // nobody listening — never block command replies on events
+			}
+			continue
+		}
+
+		select {
+		case c.replies <- rep:
+		case <-c.quit: // closed (e.g. after a command timed out)
+			c.err = ErrClosed
+			return
+		}
+	}
+}
+
+func readReply(r *bufio.Reader) (*Reply, error) {
+	rep := &Reply{}
+	for {
+		line, err := readLine(r)
+		if err != nil {
+			return nil, err
+		}
+		if len(line) < 4 {
+			return nil, fmt.Errorf("control: short line %q", line)
+		}
+		code, err := strconv.Atoi(line[:3])
+		if err != nil {
+			return nil, fmt.Errorf("control: bad status %q", line[:3])
+		}
+		rep.Status = code
+		text := line[4:]
+
+		switch line[3] {
+		case ' ':
+			rep.Lines = append(rep.Lines, text)
+			return rep, nil
+		case '-':
+			rep.Lines = append(rep.Lines, text)
+		case '+':
+			var b strings.Builder
+			b.WriteString(text)
+			for {
+				dl, err := readLine(r)
+				if err != nil {
+					return nil, err
+				}
+				if dl == "." {
+					break
+				}
+				b.WriteByte('\n')
+				b.WriteString(strings.TrimPrefix(dl, "."))
+			}
+			rep.Lines = append(rep.Lines, b.String())
+		default:
+			return nil, fmt.Errorf("control: bad separator in %q", line)
+		}
+	}
+}
+
+func readLine(r *bufio.Reader) (string, error) {
+	var b []byte
+	for {
+		chunk, isPrefix, err := r.ReadLine()
+		if err != nil {
+			return "", err
+		}
+		b = append(b, chunk...)
+		if len(b) > maxLineLen {
+			return "", errors.New("control: line too long")
+		}
+		if !isPrefix {
+			return string(b), nil
+		}
+	}
+}
+
+func safeCookieHash(key string, cookie, clientNonce, serverNonce []byte) []byte {
+	m := hmac.New(sha256.New, []byte(key))
+	m.Write(cookie)
+	m.Write(clientNonce)
+	m.Write(serverNonce)
+	return m.Sum(nil)
+}
+
+func wipe(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
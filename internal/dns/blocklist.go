@@ -0,0 +1,236 @@
+package dns
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"log/slog"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+
+	"torgo/internal/config"
+)
+
+// Local blocklists (TORGO_DNS_BLOCKLISTS): matching queries are answered
+// here and never reach tor. Two line formats are accepted, mixed freely:
+//
+//	0.0.0.0 ads.example.com tracker.example.net   hosts: exact names
+//	example.org | *.example.org | ||example.org^   domain list: name + subdomains
+//
+// Files are polled for mtime/size changes and swapped in atomically; a list
+// that fails to load keeps serving its previous contents.
+
+// Answer TTL for synthetic (blocked) responses.
+const blockTTL = 300
+
+type domainSet struct {
+	exact  map[string]struct{} // hosts entries
+	suffix map[string]struct{} // domain-list entries (match subdomains too)
+}
+
+type blocklist struct {
+	path  string
+	name  string // base name, used as the counter label
+	set   atomic.Pointer[domainSet]
+	hits  uint64
+	mtime time.Time
+	size  int64
+}
+
+var (
+	blocklists []*blocklist
+	blockMode  = config.BlockNXDomain
+)
+
+// initBlocklists loads every configured list once and starts the reloader.
+func initBlocklists(ctx context.Context, cfg *config.Config) {
+	if len(cfg.DNSBlocklists) == 0 {
+		return
+	}
+	blockMode = cfg.DNSBlockMode
+	for _, p := range cfg.DNSBlocklists {
+		bl := &blocklist{path: p, name: filepath.Base(p)}
+		bl.set.Store(&domainSet{})
+		bl.reload()
+		blocklists = append(blocklists, bl)
+	}
+	if cfg.DNSBlockReloadSecs > 0 {
+		go watchBlocklists(ctx, time.Duration(cfg.DNSBlockReloadSecs)*time.Second)
+	}
+}
+
+func watchBlocklists(ctx context.Context, every time.Duration) {
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			for _, bl := range blocklists {
+				bl.reload()
+			}
+		}
+	}
+}
+
+// reload re-reads the file if its mtime or size changed.
+func (bl *blocklist) reload() {
+	fi, err := os.Stat(bl.path)
+	if err != nil {
+		slog.Warn("dns blocklist unreadable — keeping previous entries", "list", bl.name, "err", err)
+		return
+	}
+	if fi.ModTime().Equal(bl.mtime) && fi.Size() == bl.size {
+		return
+	}
+	set, err := loadDomainSet(bl.path)
+	if err != nil {
+		slog.Warn("dns blocklist load failed — keeping previous entries", "list", bl.name, "err", err)
+		return
+	}
+	bl.set.Store(set)
+	bl.mtime, bl.size = fi.ModTime(), fi.Size()
+	slog.Info("dns blocklist loaded",
+		"list", bl.name,
+		"hosts", len(set.exact),
+		"domains", len(set.suffix),
+	)
+}
+
+func loadDomainSet(path string) (*domainSet, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	set := &domainSet{
+		exact:  make(map[string]struct{}),
+		suffix: make(map[string]struct{}),
+	}
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 0, 4096), 1<<20)
+	for sc.Scan() {
+		line := sc.Text()
+		if i := strings.IndexAny(line, "#!"); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		// hosts format: an address followed by one or more names
+		if _, err := netip.ParseAddr(fields[0]); err == nil {
+			for _, h := range fields[1:] {
+				if h = normName(h); h != "" && h != "localhost" {
+					set.exact[h] = struct{}{}
+				}
+			}
+			continue
+		}
+
+		// domain list: one name per line, optionally "*." or adblock "||...^"
+		d := strings.TrimSuffix(strings.TrimPrefix(fields[0], "||"), "^")
+		d = strings.TrimPrefix(d, "*.")
+		if d = normName(d); d != "" {
+			set.suffix[d] = struct{}{}
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("read %s: %w", path, err)
+	}
+	return set, nil
+}
+
+func normName(s string) string {
+	s = strings.ToLower(strings.TrimSuffix(s, "."))
+	if s == "" || strings.ContainsAny(s, "/:@ ") {
+		return ""
+	}
+	return s
+}
+
+// blocked reports whether name is on any list, counting the hit against
+// the first list that matches.
+func blocked(q dnsmessage.Question) bool {
+	if len(blocklists) == 0 {
+		return false
+	}
+	name := normName(q.Name.String())
+	if name == "" {
+		return false
+	}
+	for _, bl := range blocklists {
+		set := bl.set.Load()
+		if _, ok := set.exact[name]; ok {
+			atomic.AddUint64(&bl.hits, 1)
+			return true
+		}
+		for d := name; d != ""; {
+			if _, ok := set.suffix[d]; ok {
+				atomic.AddUint64(&bl.hits, 1)
+				return true
+			}
+			i := strings.IndexByte(d, '.')
+			if i < 0 {
+				break
+			}
+			d = d[i+1:]
+		}
+	}
+	return false
+}
+
+// sinkhole builds the local answer for a blocked query: NXDOMAIN, or
+// 0.0.0.0 / :: for A / AAAA (other types get an empty NOERROR).
+func sinkhole(hdr dnsmessage.Header, q dnsmessage.Question) ([]byte, error) {
+	if blockMode != config.BlockZero {
+		return rcodeReply(hdr, &q, dnsmessage.RCodeNameError)
+	}
+
+	b := dnsmessage.NewBuilder(make([]byte, 0, udpMinSize), dnsmessage.Header{
+		ID:                 hdr.ID,
+		Response:           true,
+		OpCode:             hdr.OpCode,
+		RecursionDesired:   hdr.RecursionDesired,
+		RecursionAvailable: true,
+	})
+	if err := b.StartQuestions(); err != nil {
+		return nil, err
+	}
+	if err := b.Question(q); err != nil {
+		return nil, err
+	}
+	if err := b.StartAnswers(); err != nil {
+		return nil, err
+	}
+	rh := dnsmessage.ResourceHeader{Name: q.Name, Class: q.Class, TTL: blockTTL}
+	switch q.Type {
+	case dnsmessage.TypeA:
+		if err := b.AResource(rh, dnsmessage.AResource{}); err != nil {
+			return nil, err
+		}
+	case dnsmessage.TypeAAAA:
+		if err := b.AAAAResource(rh, dnsmessage.AAAAResource{}); err != nil {
+			return nil, err
+		}
+	}
+	return b.Finish()
+}
+
+// BlocklistHits returns a snapshot of per-list hit counters keyed by file name.
+func BlocklistHits() map[string]uint64 {
+	out := make(map[string]uint64, len(blocklists))
+	for _, bl := range blocklists {
+		out[bl.name] += atomic.LoadUint64(&bl.hits)
+	}
+	return out
+}
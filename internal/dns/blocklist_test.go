@@ -0,0 +1,198 @@
+package dns
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+
+	"torgo/internal/config"
+)
+
+func writeList(t *testing.T, path string, lines ...string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func keys(m map[string]struct{}) []string {
+	out := make([]string, 0, len(m))
+	for k := range m {
+		out = append(out, k)
+	}
+	slices.Sort(out)
+	return out
+}
+
+func TestLoadDomainSet(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "list.txt")
+	writeList(t, path,
+		"# hosts section",
+		"0.0.0.0 ads.example.com Tracker.Example.NET.",
+		"127.0.0.1 localhost",
+		"::1 localhost ip6-localhost",
+		"0.0.0.0 ads.example.com # duplicate, trailing comment",
+		"",
+		"   ! adblock comment",
+		"example.org",
+		"*.wild.example",
+		"||adblock.example^",
+		"Upper.Example. extra fields ignored",
+		"http://not-a-name/",
+		"user@host",
+	)
+
+	set, err := loadDomainSet(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := keys(set.exact), []string{"ads.example.com", "ip6-localhost", "tracker.example.net"}; !slices.Equal(got, want) {
+		t.Errorf("exact = %v, want %v", got, want)
+	}
+	if got, want := keys(set.suffix), []string{"adblock.example", "example.org", "upper.example", "wild.example"}; !slices.Equal(got, want) {
+		t.Errorf("suffix = %v, want %v", got, want)
+	}
+
+	if _, err := loadDomainSet(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Error("missing file loaded, want error")
+	}
+}
+
+func TestBlocked(t *testing.T) {
+	dir := t.TempDir()
+	hosts, domains := filepath.Join(dir, "hosts"), filepath.Join(dir, "domains")
+	writeList(t, hosts, "0.0.0.0 ads.example.com")
+	writeList(t, domains, "tracker.example", "||co.uk^")
+
+	defer func(b []*blocklist) { blocklists = b }(blocklists)
+	blocklists = nil
+	for _, p := range []string{hosts, domains} {
+		bl := &blocklist{path: p, name: filepath.Base(p)}
+		bl.set.Store(&domainSet{})
+		bl.reload()
+		blocklists = append(blocklists, bl)
+	}
+
+	tests := []struct {
+		name string
+		want bool
+		list int // index of the list the hit is counted against
+	}{
+		{"ads.example.com.", true, 0},
+		{"ADS.Example.COM.", true, 0},
+		{"sub.ads.example.com.", false, 0}, // hosts entries are exact
+		{"example.com.", false, 0},
+		{"tracker.example.", true, 1},
+		{"a.b.tracker.example.", true, 1},
+		{"nottracker.example.", false, 0},
+		{"example.", false, 0},
+		{"news.bbc.co.uk.", true, 1},
+		{"co.uk.", true, 1},
+		{"uk.", false, 0},
+		{".", false, 0},
+	}
+
+	for _, tt := range tests {
+		before := blocklists[tt.list].hits
+		q := dnsmessage.Question{Name: dnsmessage.MustNewName(tt.name), Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET}
+		if got := blocked(q); got != tt.want {
+			t.Errorf("blocked(%s) = %v, want %v", tt.name, got, tt.want)
+		}
+		if tt.want && blocklists[tt.list].hits != before+1 {
+			t.Errorf("blocked(%s): hit not counted against %s", tt.name, blocklists[tt.list].name)
+		}
+	}
+}
+
+func TestBlocklistReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "list.txt")
+	writeList(t, path, "one.example")
+	bl := &blocklist{path: path, name: "list.txt"}
+	bl.set.Store(&domainSet{})
+
+	has := func(name string) bool {
+		_, ok := bl.set.Load().suffix[name]
+		return ok
+	}
+	touch := func(mtime time.Time) {
+		if err := os.Chtimes(path, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	bl.reload()
+	if !has("one.example") {
+		t.Fatal("initial load missed one.example")
+	}
+	loaded := bl.mtime
+
+	// Same size, same mtime: not re-read
+	writeList(t, path, "two.example")
+	touch(loaded)
+	bl.reload()
+	if !has("one.example") || has("two.example") {
+		t.Error("reloaded although mtime and size are unchanged")
+	}
+
+	// Same size, new mtime: re-read
+	touch(loaded.Add(time.Second))
+	bl.reload()
+	if has("one.example") || !has("two.example") {
+		t.Error("mtime change not picked up")
+	}
+
+	// Same mtime, new size: re-read
+	writeList(t, path, "three.example")
+	touch(loaded.Add(time.Second))
+	bl.reload()
+	if !has("three.example") {
+		t.Error("size change not picked up")
+	}
+
+	// Unreadable: previous entries kept
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	bl.reload()
+	if !has("three.example") {
+		t.Error("entries dropped when the file vanished")
+	}
+}
+
+func TestSinkhole(t *testing.T) {
+	defer func(m string) { blockMode = m }(blockMode)
+	hdr := dnsmessage.Header{ID: 11, RecursionDesired: true}
+
+	tests := []struct {
+		mode    string
+		qtype   dnsmessage.Type
+		rcode   dnsmessage.RCode
+		answers int
+	}{
+		{config.BlockNXDomain, dnsmessage.TypeA, dnsmessage.RCodeNameError, 0},
+		{config.BlockZero, dnsmessage.TypeA, dnsmessage.RCodeSuccess, 1},
+		{config.BlockZero, dnsmessage.TypeAAAA, dnsmessage.RCodeSuccess, 1},
+		{config.BlockZero, dnsmessage.TypeMX, dnsmessage.RCodeSuccess, 0},
+	}
+	for _, tt := range tests {
+		blockMode = tt.mode
+		q := dnsmessage.Question{Name: dnsmessage.MustNewName("ads.example.com."), Type: tt.qtype, Class: dnsmessage.ClassINET}
+		out, err := sinkhole(hdr, q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var m dnsmessage.Message
+		if err := m.Unpack(out); err != nil {
+			t.Fatal(err)
+		}
+		if m.ID != 11 || m.RCode != tt.rcode || len(m.Answers) != tt.answers {
+			t.Errorf("%s %v: got rcode %v with %d answers, want %v with %d",
+				tt.mode, tt.qtype, m.RCode, len(m.Answers), tt.rcode, tt.answers)
+		}
+	}
+}
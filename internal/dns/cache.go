@@ -0,0 +1,243 @@
+package dns
+
+import (
+	"container/list"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+
+	"torgo/internal/config"
+)
+
+// Optional answer cache (TORGO_DNS_CACHE_SIZE > 0). Entries are keyed by a
+// salted hash of (client?, name, type, class), so the index never holds
+// names in the clear; bodies are wiped on eviction. Everything lives on the
+// Go heap, which secmem mlocks, and the cache is bounded by entry count and
+// entry size so that locked footprint stays predictable.
+
+// Answers bigger than this are never cached.
+const maxCachedMsg = udpMaxSize
+
+type cacheEntry struct {
+	key     [32]byte
+	msg     []byte // packed response as received from tor
+	stored  time.Time
+	expires time.Time
+}
+
+type dnsCache struct {
+	mu      sync.Mutex
+	size    int
+	entries map[[32]byte]*list.Element
+	lru     *list.List // front = most recently used
+
+	minTTL, maxTTL, negTTL time.Duration
+	perClient              bool
+	salt                   [32]byte
+}
+
+var cache *dnsCache // nil = disabled
+
+var cacheHits, cacheMisses uint64
+
+func initCache(cfg *config.Config) {
+	if cfg.DNSCacheSize <= 0 {
+		return
+	}
+	c := &dnsCache{
+		size:      cfg.DNSCacheSize,
+		entries:   make(map[[32]byte]*list.Element, cfg.DNSCacheSize),
+		lru:       list.New(),
+		minTTL:    time.Duration(cfg.DNSCacheMinTTL) * time.Second,
+		maxTTL:    time.Duration(cfg.DNSCacheMaxTTL) * time.Second,
+		negTTL:    time.Duration(cfg.DNSCacheNegTTL) * time.Second,
+		perClient: cfg.DNSCachePerClient,
+	}
+	_, _ = rand.Read(c.salt[:])
+	cache = c
+}
+
+// resolve answers q locally when possible (blocklist, then cache),
+// otherwise through tor (exchange), running the rebinding filter and
+// caching the result. client is the querier's address, only used when
+// per-client isolation is on.
+func resolve(insts []*config.Instance, client string, q []byte, hdr dnsmessage.Header, question dnsmessage.Question) ([]byte, error) {
+	// Blocklisted names are answered locally and never reach tor or the cache
+	if blocked(question) {
+		return sinkhole(hdr, question)
+	}
+
+	c := cache
+	var key [32]byte
+	if c != nil {
+		key = c.key(client, question)
+		if resp := c.get(key, hdr, question); resp != nil {
+			atomic.AddUint64(&cacheHits, 1)
+			return resp, nil
+		}
+		atomic.AddUint64(&cacheMisses, 1)
+	}
+
+	resp, err := exchange(insts, q)
+	if err != nil {
+		return nil, err
+	}
+	// Filtered before caching, so a blocked answer is never served from cache
+	if resp, err = filterRebind(hdr, question, resp); err != nil {
+		return nil, err
+	}
+	if c != nil {
+		c.put(key, resp)
+	}
+	return resp, nil
+}
+
+// clientHost reduces a client address to its host part (the source port
+// changes per query and must not split one client's cache).
+func clientHost(a net.Addr) string {
+	if a == nil {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(a.String())
+	if err != nil {
+		return a.String()
+	}
+	return host
+}
+
+func (c *dnsCache) key(client string, q dnsmessage.Question) [32]byte {
+	h := sha256.New()
+	h.Write(c.salt[:])
+	if c.perClient {
+		h.Write([]byte(client))
+		h.Write([]byte{0})
+	}
+	h.Write([]byte(strings.ToLower(q.Name.String())))
+	var tc [4]byte
+	binary.BigEndian.PutUint16(tc[0:], uint16(q.Type))
+	binary.BigEndian.PutUint16(tc[2:], uint16(q.Class))
+	h.Write(tc[:])
+
+	var k [32]byte
+	copy(k[:], h.Sum(nil))
+	return k
+}
+
+// get returns a cached answer rewritten for this query (ID, question as
+// the client spelled it, TTLs counted down), or nil.
+func (c *dnsCache) get(key [32]byte, hdr dnsmessage.Header, q dnsmessage.Question) []byte {
+	c.mu.Lock()
+	el, ok := c.entries[key]
+	if !ok {
+		c.mu.Unlock()
+		return nil
+	}
+	e := el.Value.(*cacheEntry)
+	now := time.Now()
+	if !now.Before(e.expires) {
+		c.remove(el)
+		c.mu.Unlock()
+		return nil
+	}
+	c.lru.MoveToFront(el)
+	var m dnsmessage.Message
+	err := m.Unpack(e.msg)
+	age := uint32(now.Sub(e.stored) / time.Second)
+	c.mu.Unlock()
+	if err != nil {
+		return nil
+	}
+
+	m.ID = hdr.ID
+	m.RecursionDesired = hdr.RecursionDesired
+	m.Questions = []dnsmessage.Question{q}
+	for _, sec := range [][]dnsmessage.Resource{m.Answers, m.Authorities, m.Additionals} {
+		for i := range sec {
+			if sec[i].Header.Type == dnsmessage.TypeOPT {
+				continue // CLASS/TTL carry EDNS fields, not a TTL
+			}
+			if sec[i].Header.TTL > age {
+				sec[i].Header.TTL -= age
+			} else {
+				sec[i].Header.TTL = 0
+			}
+		}
+	}
+	out, err := m.Pack()
+	if err != nil {
+		return nil
+	}
+	return out
+}
+
+// put stores resp if it is cacheable: positive answers for their smallest
+// TTL (clamped), NXDOMAIN/NODATA for the negative TTL. Errors, truncated
+// answers and anything oversized are never stored.
+func (c *dnsCache) put(key [32]byte, resp []byte) {
+	if len(resp) > maxCachedMsg {
+		return
+	}
+	var m dnsmessage.Message
+	if err := m.Unpack(resp); err != nil || m.Truncated {
+		return
+	}
+
+	var ttl time.Duration
+	switch {
+	case m.RCode == dnsmessage.RCodeSuccess && len(m.Answers) > 0:
+		lowest := ^uint32(0)
+		for _, a := range m.Answers {
+			if a.Header.TTL < lowest {
+				lowest = a.Header.TTL
+			}
+		}
+		ttl = time.Duration(lowest) * time.Second
+		if ttl < c.minTTL {
+			ttl = c.minTTL
+		}
+		if ttl > c.maxTTL {
+			ttl = c.maxTTL
+		}
+	case m.RCode == dnsmessage.RCodeNameError, m.RCode == dnsmessage.RCodeSuccess:
+		ttl = c.negTTL
+	default:
+		return
+	}
+	if ttl <= 0 {
+		return
+	}
+
+	msg := make([]byte, len(resp))
+	copy(msg, resp)
+	now := time.Now()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[key]; ok {
+		c.remove(el)
+	}
+	for c.lru.Len() >= c.size {
+		c.remove(c.lru.Back())
+	}
+	c.entries[key] = c.lru.PushFront(&cacheEntry{key: key, msg: msg, stored: now, expires: now.Add(ttl)})
+}
+
+func (c *dnsCache) len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lru.Len()
+}
+
+// remove drops el and wipes its body (Anti-Forensics). Caller holds c.mu.
+func (c *dnsCache) remove(el *list.Element) {
+	e := c.lru.Remove(el).(*cacheEntry)
+	delete(c.entries, e.key)
+	wipe(e.msg)
+}
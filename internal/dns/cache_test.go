@@ -0,0 +1,173 @@
+package dns
+
+import (
+	"container/list"
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+func question(name string) dnsmessage.Question {
+	return dnsmessage.Question{Name: dnsmessage.MustNewName(name), Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET}
+}
+
+// response packs a reply to name with rcode and one A record per TTL.
+func response(t *testing.T, name string, rcode dnsmessage.RCode, tc bool, ttls ...uint32) []byte {
+	t.Helper()
+	q := question(name)
+	m := dnsmessage.Message{
+		Header:    dnsmessage.Header{ID: 1, Response: true, RCode: rcode, Truncated: tc},
+		Questions: []dnsmessage.Question{q},
+	}
+	for i, ttl := range ttls {
+		m.Answers = append(m.Answers, dnsmessage.Resource{
+			Header: dnsmessage.ResourceHeader{Name: q.Name, Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET, TTL: ttl},
+			Body:   &dnsmessage.AResource{A: [4]byte{93, 184, 216, byte(i + 1)}},
+		})
+	}
+	msg, err := m.Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return msg
+}
+
+func newTestCache(size int, minTTL, maxTTL, negTTL time.Duration) *dnsCache {
+	return &dnsCache{
+		size:    size,
+		entries: make(map[[32]byte]*list.Element),
+		lru:     list.New(),
+		minTTL:  minTTL,
+		maxTTL:  maxTTL,
+		negTTL:  negTTL,
+	}
+}
+
+func TestCachePutTTL(t *testing.T) {
+	tests := []struct {
+		name  string
+		rcode dnsmessage.RCode
+		tc    bool
+		ttls  []uint32
+		want  time.Duration // 0 = must not be stored
+	}{
+		{name: "lowest answer ttl wins", ttls: []uint32{300, 120, 600}, want: 120 * time.Second},
+		{name: "clamped up to min", ttls: []uint32{5}, want: 30 * time.Second},
+		{name: "clamped down to max", ttls: []uint32{86400}, want: time.Hour},
+		{name: "zero ttl clamped up to min", ttls: []uint32{0}, want: 30 * time.Second},
+		{name: "nxdomain uses negative ttl", rcode: dnsmessage.RCodeNameError, want: 10 * time.Second},
+		{name: "nodata uses negative ttl", want: 10 * time.Second},
+		{name: "servfail never stored", rcode: dnsmessage.RCodeServerFailure},
+		{name: "refused never stored", rcode: dnsmessage.RCodeRefused, ttls: []uint32{300}},
+		{name: "truncated never stored", tc: true, ttls: []uint32{300}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestCache(8, 30*time.Second, time.Hour, 10*time.Second)
+			key := c.key("", question("example.com."))
+			c.put(key, response(t, "example.com.", tt.rcode, tt.tc, tt.ttls...))
+
+			el, ok := c.entries[key]
+			if tt.want == 0 {
+				if ok {
+					t.Fatal("stored, want skipped")
+				}
+				return
+			}
+			if !ok {
+				t.Fatal("not stored")
+			}
+			e := el.Value.(*cacheEntry)
+			if got := e.expires.Sub(e.stored); got != tt.want {
+				t.Errorf("ttl = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCacheNegativeTTLOff(t *testing.T) {
+	c := newTestCache(8, 0, time.Hour, 0)
+	key := c.key("", question("nx.example."))
+	c.put(key, response(t, "nx.example.", dnsmessage.RCodeNameError, false))
+	if c.len() != 0 {
+		t.Errorf("len = %d, want 0 with negative caching off", c.len())
+	}
+}
+
+func TestCacheEviction(t *testing.T) {
+	c := newTestCache(2, 0, time.Hour, time.Minute)
+	q := map[string]dnsmessage.Question{"a": question("a.example."), "b": question("b.example."), "c": question("c.example.")}
+	key := func(n string) [32]byte { return c.key("", q[n]) }
+	put := func(n string) { c.put(key(n), response(t, q[n].Name.String(), 0, false, 300)) }
+	get := func(n string) bool { return c.get(key(n), dnsmessage.Header{ID: 7}, q[n]) != nil }
+
+	put("a")
+	put("b")
+	if !get("a") { // a is now the most recently used
+		t.Fatal("a missing")
+	}
+	put("c") // evicts b, the least recently used
+	if get("b") {
+		t.Error("b survived, want it evicted")
+	}
+	if !get("a") || !get("c") {
+		t.Error("a or c evicted, want b")
+	}
+
+	put("c") // replacing an entry must not evict another
+	if c.len() != 2 || !get("a") {
+		t.Errorf("len = %d after re-put, want 2 with a kept", c.len())
+	}
+}
+
+func TestCacheGet(t *testing.T) {
+	c := newTestCache(8, 0, time.Hour, time.Minute)
+	stored := question("example.com.")
+	key := c.key("", stored)
+	c.put(key, response(t, "example.com.", 0, false, 300))
+
+	// Pretend the entry is 100s old
+	e := c.entries[key].Value.(*cacheEntry)
+	e.stored = e.stored.Add(-100 * time.Second)
+
+	asked := question("EXAMPLE.com.")
+	if key != c.key("", asked) {
+		t.Fatal("key is case-sensitive")
+	}
+	out := c.get(key, dnsmessage.Header{ID: 0xbeef, RecursionDesired: true}, asked)
+	if out == nil {
+		t.Fatal("miss")
+	}
+	var m dnsmessage.Message
+	if err := m.Unpack(out); err != nil {
+		t.Fatal(err)
+	}
+	if m.ID != 0xbeef || !m.RecursionDesired {
+		t.Errorf("header = %+v, want the query's ID and RD", m.Header)
+	}
+	if m.Questions[0].Name.String() != "EXAMPLE.com." {
+		t.Errorf("question = %s, want the client's spelling", m.Questions[0].Name)
+	}
+	if ttl := m.Answers[0].Header.TTL; ttl != 200 {
+		t.Errorf("ttl = %d, want 200", ttl)
+	}
+
+	e.expires = time.Now().Add(-time.Second)
+	if c.get(key, dnsmessage.Header{}, asked) != nil || c.len() != 0 {
+		t.Error("expired entry served or kept")
+	}
+}
+
+func TestCacheKeyPerClient(t *testing.T) {
+	c := newTestCache(8, 0, time.Hour, time.Minute)
+	q := question("example.com.")
+	if c.key("10.0.0.1", q) != c.key("10.0.0.2", q) {
+		t.Error("shared cache split by client")
+	}
+	c.perClient = true
+	if c.key("10.0.0.1", q) == c.key("10.0.0.2", q) {
+		t.Error("per-client cache shared between clients")
+	}
+}
@@ -3,14 +3,20 @@ package dns
 import (
 	"context"
 	"crypto/rand"
-	"fmt"
+	"encoding/binary"
+	"errors"
+	"io"
 	"log/slog"
 	"math/big"
 	"net"
+	"sync"
 	"sync/atomic"
 	"time"
 
+	"golang.org/x/net/dns/dnsmessage"
+
 	"torgo/internal/config"
+	"torgo/internal/registry"
 )
 
 // Hard limits — now tunable via config/env
@@ -18,8 +24,15 @@ var (
 	dnsMaxConns       uint32 = 256
 	dnsMaxPerInstance uint32 = 64
 	dnsConnTimeout           = 30 * time.Second
+	dnsDialTimeout           = 3 * time.Second
 )
 
+// Upper bound on instances tried per query before giving up.
+const maxDialAttempts = 3
+
+// Queries one TCP client may have in flight at once.
+const maxPipelined = 16
+
 // Atomic counters (lock-free)
 var (
 	totalDNSConns   uint32
@@ -34,34 +47,67 @@ func Start(ctx context.Context, insts []*config.Instance, cfg *config.Config) {
 	if cfg.DNSMaxConnsPerInst > 0 {
 		dnsMaxPerInstance = uint32(cfg.DNSMaxConnsPerInst)
 	}
+	initCache(cfg)
+	rebindMode = cfg.DNSRebindFilter
+	initBlocklists(ctx, cfg)
 
-	addr := net.JoinHostPort("0.0.0.0", cfg.DNSPort)
-	l, err := net.Listen("tcp", addr)
-	if err != nil {
-		slog.Error("dns bind failed", "err", err)
+	go serveDoH(ctx, cfg, insts)
+	go serveDoT(ctx, cfg, insts)
+
+	// Each listener (COMMON_DNS_LISTEN) serves TCP, plus UDP on IP addresses
+	var addrs []string
+	for _, spec := range cfg.DNSListeners {
+		l, err := spec.Listen()
+		if err != nil {
+			slog.Error("dns bind failed", "listener", spec, "err", err)
+			continue
+		}
+		defer l.Close()
+		addrs = append(addrs, spec.String())
+		if spec.Network == "tcp" {
+			go serveUDP(ctx, spec.Addr, insts, spec.MaxConns)
+		}
+		go serveTCP(l, insts, spec.MaxConns)
+	}
+	if len(addrs) == 0 {
 		return
 	}
-	defer l.Close()
 	slog.Info("DNS-over-TCP proxy active",
-		"addr", l.Addr(),
+		"listeners", addrs,
 		"maxDNSConns", dnsMaxConns,
 		"maxPerInstance", dnsMaxPerInstance,
+		"cacheSize", cfg.DNSCacheSize,
+		"cachePerClient", cfg.DNSCachePerClient,
+		"rebindFilter", rebindMode,
+		"blocklists", len(blocklists),
 	)
 
+	<-ctx.Done()
+}
+
+// serveTCP accepts stream clients on l; maxConns > 0 caps this listener
+// on top of dnsMaxConns.
+func serveTCP(l net.Listener, insts []*config.Instance, maxConns int) {
+	var active int32
 	for {
 		c, err := l.Accept()
 		if err != nil {
 			return
 		}
 
-		// Global limit check
-		if atomic.LoadUint32(&totalDNSConns) >= dnsMaxConns {
+		// Global limit check, then the listener's own
+		if atomic.LoadUint32(&totalDNSConns) >= dnsMaxConns ||
+			(maxConns > 0 && atomic.LoadInt32(&active) >= int32(maxConns)) {
 			_ = c.Close()
 			continue
 		}
 		atomic.AddUint32(&totalDNSConns, 1)
+		atomic.AddInt32(&active, 1)
 
-		go handleDNS(c, insts)
+		go func() {
+			defer atomic.AddInt32(&active, -1)
+			handleDNS(c, insts)
+		}()
 	}
 }
 
@@ -77,22 +123,107 @@ func handleDNS(client net.Conn, insts []*config.Instance) {
 	defer client.Close()
 	defer atomic.AddUint32(&totalDNSConns, ^uint32(0))
 
-	// Security: Set deadline immediately
-	_ = client.SetDeadline(time.Now().Add(dnsConnTimeout))
+	serveStream(client, insts, dnsConnTimeout)
+}
 
-	instCount := len(insts)
-	if instCount == 0 {
-		return
+// serveStream answers length-prefixed queries on a stream (plain TCP or
+// DoT) until the client goes quiet for idle or hangs up.
+func serveStream(client net.Conn, insts []*config.Instance, idle time.Duration) {
+	// Pipelined queries are answered as they complete (RFC 7766 §6.2.1.1),
+	// each balanced on its own; the semaphore bounds one client's share
+	var (
+		wmu      sync.Mutex
+		inflight = make(chan struct{}, maxPipelined)
+		wg       sync.WaitGroup
+	)
+	defer wg.Wait()
+
+	for {
+		// Security: idle deadline, renewed per message
+		_ = client.SetDeadline(time.Now().Add(idle))
+
+		q, err := readMsg(client)
+		if err != nil {
+			return
+		}
+
+		// Validate before anything reaches tor: malformed queries get
+		// FORMERR, a client sending responses gets hung up on
+		hdr, question, _, err := parseQuery(q)
+		if err != nil {
+			wipe(q)
+			if hdr.Response {
+				return
+			}
+			resp, err := rcodeReply(hdr, nil, dnsmessage.RCodeFormatError)
+			if err != nil || writeMsg(client, &wmu, resp) != nil {
+				return
+			}
+			continue
+		}
+
+		inflight <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-inflight }()
+			defer wipe(q)
+
+			resp, err := resolve(insts, clientHost(client.RemoteAddr()), q, hdr, question)
+			if err != nil {
+				// Don't leave a TCP client waiting on an answer that won't come
+				if resp, err = rcodeReply(hdr, &question, dnsmessage.RCodeServerFailure); err != nil {
+					return
+				}
+			}
+			defer wipe(resp)
+			if writeMsg(client, &wmu, resp) != nil {
+				_ = client.Close()
+			}
+		}()
+	}
+}
+
+// readMsg reads one length-prefixed DNS message (RFC 1035 §4.2.2).
+func readMsg(r io.Reader) ([]byte, error) {
+	var l [2]byte
+	if _, err := io.ReadFull(r, l[:]); err != nil {
+		return nil, err
+	}
+	n := int(binary.BigEndian.Uint16(l[:]))
+	if n < 12 {
+		return nil, errors.New("dns: short message")
 	}
-	if instCount > 32 {
-		instCount = 32
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r, b); err != nil {
+		wipe(b)
+		return nil, err
 	}
+	return b, nil
+}
+
+// writeMsg writes one length-prefixed message; mu serialises pipelined replies.
+func writeMsg(w io.Writer, mu *sync.Mutex, m []byte) error {
+	if len(m) > 0xFFFF {
+		return errors.New("dns: message too large")
+	}
+	b := make([]byte, 2+len(m))
+	binary.BigEndian.PutUint16(b, uint16(len(m)))
+	copy(b[2:], m)
+	defer wipe(b)
+
+	mu.Lock()
+	defer mu.Unlock()
+	_, err := w.Write(b)
+	return err
+}
 
+// pickInstance is a least-loaded walk from a random start, skipping slots in skip.
+func pickInstance(insts []*config.Instance, instCount int, skip uint32) int {
 	// Pick a random start index for load balancing
 	randIdx, _ := rand.Int(rand.Reader, big.NewInt(int64(instCount)))
 	start := int(randIdx.Int64())
 
-	var chosen *config.Instance
 	chosenIdx := -1
 	var bestLoad uint32 = ^uint32(0)
 
@@ -100,6 +231,15 @@ func handleDNS(client net.Conn, insts []*config.Instance) {
 	for off := 0; off < instCount; off++ {
 		idx := (start + off) % instCount
 
+		if skip&(1<<uint(idx)) != 0 {
+			continue
+		}
+
+		// Dead, still bootstrapping, marked unhealthy by health or drained
+		if !insts[idx].Ready() || !registry.DNSHealthy(idx) || registry.Drained(idx) {
+			continue
+		}
+
 		load := atomic.LoadUint32(&perInstDNSConns[idx])
 		if load >= dnsMaxPerInstance {
 			continue
@@ -109,55 +249,5 @@ func handleDNS(client net.Conn, insts []*config.Instance) {
 			chosenIdx = idx
 		}
 	}
-
-	if chosenIdx < 0 {
-		return // all instances busy
-	}
-
-	if atomic.AddUint32(&perInstDNSConns[chosenIdx], 1) > dnsMaxPerInstance {
-		atomic.AddUint32(&perInstDNSConns[chosenIdx], ^uint32(0))
-		return
-	}
-	defer atomic.AddUint32(&perInstDNSConns[chosenIdx], ^uint32(0))
-
-	chosen = insts[chosenIdx]
-
-	// FIX: Use fmt.Sprintf
-	addr := fmt.Sprintf("127.0.0.1:%d", chosen.DNSPort)
-
-	torDNS, err := net.Dial("tcp", addr)
-	if err != nil {
-		return
-	}
-	defer torDNS.Close()
-	_ = torDNS.SetDeadline(time.Now().Add(dnsConnTimeout))
-
-	// DNS messages are tiny → use small fixed buffer + bounded copy
-	go boundedCopy(torDNS, client)
-	boundedCopy(client, torDNS)
+	return chosenIdx
 }
-
-func boundedCopy(dst net.Conn, src net.Conn) {
-	// 2. SECURE MEMORY (Smaller 4KB buffer for DNS)
-	buf := make([]byte, 4096)
-
-	// 3. IMMEDIATE WIPE (Anti-Forensics)
-	defer func() {
-		for i := range buf {
-			buf[i] = 0
-		}
-	}()
-
-	for {
-		nr, er := src.Read(buf)
-		if nr > 0 {
-			nw, ew := dst.Write(buf[:nr])
-			if ew != nil || nw < nr {
-				break
-			}
-		}
-		if er != nil {
-			break
-		}
-	}
-}
\ No newline at end of file
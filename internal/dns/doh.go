@@ -0,0 +1,163 @@
+package dns
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"io"
+	"log"
+	"log/slog"
+	"mime"
+	"net"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+
+	"torgo/internal/config"
+)
+
+const dohMediaType = "application/dns-message"
+
+// serveDoH runs the optional RFC 8484 endpoint (COMMON_DOH_PORT). Each
+// request is one query and counts against dnsMaxConns while in flight;
+// forwarding goes through resolve like every other frontend.
+func serveDoH(ctx context.Context, cfg *config.Config, insts []*config.Instance) {
+	if cfg.DoHPort == "" {
+		return
+	}
+	tc, err := serverTLS(cfg)
+	if err != nil {
+		slog.Error("doh disabled", "err", err)
+		return
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(cfg.DoHPath, func(w http.ResponseWriter, r *http.Request) {
+		handleDoH(w, r, insts)
+	})
+
+	srv := &http.Server{
+		Addr:              net.JoinHostPort(cfg.DNSBindAddr, cfg.DoHPort),
+		Handler:           mux,
+		TLSConfig:         tc,
+		ReadHeaderTimeout: 10 * time.Second,
+		ReadTimeout:       dnsConnTimeout,
+		WriteTimeout:      dnsConnTimeout,
+		IdleTimeout:       dnsConnTimeout,
+		MaxHeaderBytes:    16 << 10,
+		// TLS handshake noise from scanners would otherwise hit stderr
+		ErrorLog: log.New(io.Discard, "", 0),
+	}
+	go func() {
+		<-ctx.Done()
+		_ = srv.Close()
+	}()
+
+	slog.Info("DNS-over-HTTPS proxy active", "addr", srv.Addr, "path", cfg.DoHPath)
+	if err := srv.ListenAndServeTLS("", ""); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		slog.Error("doh bind failed", "err", err)
+	}
+}
+
+func handleDoH(w http.ResponseWriter, r *http.Request, insts []*config.Instance) {
+	// 1. PANIC RECOVERY
+	defer func() {
+		if rec := recover(); rec != nil {
+			slog.Error("doh panic recovered", "err", rec)
+		}
+	}()
+
+	var q []byte
+	switch r.Method {
+	case http.MethodGet:
+		var err error
+		q, err = base64.RawURLEncoding.DecodeString(r.URL.Query().Get("dns"))
+		if err != nil || len(q) == 0 {
+			http.Error(w, "bad dns parameter", http.StatusBadRequest)
+			return
+		}
+	case http.MethodPost:
+		if mt, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mt != dohMediaType {
+			http.Error(w, "unsupported media type", http.StatusUnsupportedMediaType)
+			return
+		}
+		var err error
+		q, err = io.ReadAll(io.LimitReader(r.Body, 0xFFFF+1))
+		if err != nil {
+			return
+		}
+		if len(q) > 0xFFFF {
+			wipe(q)
+			http.Error(w, "query too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	defer wipe(q)
+
+	// Global limit check (shared with UDP/TCP)
+	if atomic.AddUint32(&totalDNSConns, 1) > dnsMaxConns {
+		atomic.AddUint32(&totalDNSConns, ^uint32(0))
+		http.Error(w, "busy", http.StatusServiceUnavailable)
+		return
+	}
+	defer atomic.AddUint32(&totalDNSConns, ^uint32(0))
+
+	// Malformed queries never reach tor
+	hdr, question, _, err := parseQuery(q)
+	if err != nil {
+		http.Error(w, "malformed dns message", http.StatusBadRequest)
+		return
+	}
+
+	host, _, _ := net.SplitHostPort(r.RemoteAddr)
+	resp, err := resolve(insts, host, q, hdr, question)
+	if err != nil {
+		if resp, err = rcodeReply(hdr, &question, dnsmessage.RCodeServerFailure); err != nil {
+			http.Error(w, "upstream failure", http.StatusBadGateway)
+			return
+		}
+	}
+	defer wipe(resp)
+
+	w.Header().Set("Content-Type", dohMediaType)
+	w.Header().Set("Cache-Control", "max-age="+strconv.FormatUint(uint64(lowestTTL(resp)), 10))
+	w.Header().Set("Content-Length", strconv.Itoa(len(resp)))
+	_, _ = w.Write(resp)
+}
+
+// lowestTTL is the smallest answer TTL, the freshness lifetime RFC 8484
+// §5.1 asks DoH responses to advertise. 0 when there is nothing to cache.
+func lowestTTL(resp []byte) uint32 {
+	var p dnsmessage.Parser
+	if _, err := p.Start(resp); err != nil {
+		return 0
+	}
+	if err := p.SkipAllQuestions(); err != nil {
+		return 0
+	}
+	lowest, seen := ^uint32(0), false
+	for {
+		rh, err := p.AnswerHeader()
+		if err != nil {
+			break
+		}
+		if rh.TTL < lowest {
+			lowest = rh.TTL
+		}
+		seen = true
+		if err := p.SkipAnswer(); err != nil {
+			break
+		}
+	}
+	if !seen {
+		return 0
+	}
+	return lowest
+}
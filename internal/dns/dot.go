@@ -0,0 +1,95 @@
+package dns
+
+import (
+	"context"
+	"crypto/tls"
+	"log/slog"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"torgo/internal/config"
+)
+
+// DoT connections are long-lived, so they get their own cap on top of the
+// shared dnsMaxConns budget.
+var (
+	dotMaxConns   uint32 = 128
+	dotIdle              = 30 * time.Second
+	totalDoTConns uint32
+)
+
+// serveDoT runs the optional RFC 7858 listener (COMMON_DOT_PORT). After the
+// TLS handshake a DoT stream is plain DNS-over-TCP, answered by serveStream.
+func serveDoT(ctx context.Context, cfg *config.Config, insts []*config.Instance) {
+	if cfg.DoTPort == "" {
+		return
+	}
+	if cfg.DoTMaxConns > 0 {
+		dotMaxConns = uint32(cfg.DoTMaxConns)
+	}
+	if cfg.DoTIdleSecs > 0 {
+		dotIdle = time.Duration(cfg.DoTIdleSecs) * time.Second
+	}
+
+	tc, err := serverTLS(cfg)
+	if err != nil {
+		slog.Error("dot disabled", "err", err)
+		return
+	}
+
+	l, err := net.Listen("tcp", net.JoinHostPort(cfg.DNSBindAddr, cfg.DoTPort))
+	if err != nil {
+		slog.Error("dot bind failed", "err", err)
+		return
+	}
+	defer l.Close()
+	go func() {
+		<-ctx.Done()
+		_ = l.Close()
+	}()
+	slog.Info("DNS-over-TLS proxy active",
+		"addr", l.Addr(),
+		"maxDoTConns", dotMaxConns,
+		"idle", dotIdle,
+	)
+
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			return
+		}
+
+		// DoT cap first, then the global DNS budget
+		if atomic.LoadUint32(&totalDoTConns) >= dotMaxConns ||
+			atomic.LoadUint32(&totalDNSConns) >= dnsMaxConns {
+			_ = c.Close()
+			continue
+		}
+		atomic.AddUint32(&totalDoTConns, 1)
+		atomic.AddUint32(&totalDNSConns, 1)
+
+		go handleDoT(tls.Server(c, tc), insts)
+	}
+}
+
+func handleDoT(client *tls.Conn, insts []*config.Instance) {
+	// 1. PANIC RECOVERY
+	defer func() {
+		if r := recover(); r != nil {
+			slog.Error("dot panic recovered", "err", r)
+		}
+	}()
+
+	defer client.Close()
+	defer atomic.AddUint32(&totalDNSConns, ^uint32(0))
+	defer atomic.AddUint32(&totalDoTConns, ^uint32(0))
+
+	// Handshake under the idle deadline so a silent client can't hold a slot
+	_ = client.SetDeadline(time.Now().Add(dotIdle))
+	if err := client.Handshake(); err != nil {
+		return
+	}
+
+	serveStream(client, insts, dotIdle)
+}
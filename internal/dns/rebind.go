@@ -0,0 +1,90 @@
+package dns
+
+import (
+	"net/netip"
+	"strings"
+	"sync/atomic"
+
+	"golang.org/x/net/dns/dnsmessage"
+
+	"torgo/internal/config"
+)
+
+// DNS rebinding filter: a hostile name must not resolve to something on
+// our side of the tunnel (LAN, loopback, link-local). tor's own automap
+// range is exempt, but only for the suffixes tor automaps (torrc.template).
+var (
+	rebindMode    = config.RebindOff
+	automapRange  = netip.MustParsePrefix("10.192.0.0/10") // VirtualAddrNetworkIPv4
+	sharedRange   = netip.MustParsePrefix("100.64.0.0/10") // RFC 6598 CGNAT, LAN-like
+	automapSuffix = []string{".onion.", ".exit."}          // AutomapHostsSuffixes
+	rebindBlocked uint64                                   // records stripped or answers refused
+)
+
+// internalAddr reports whether a is an address that must never come back
+// from a public name.
+func internalAddr(a netip.Addr) bool {
+	a = a.Unmap()
+	return a.IsPrivate() || a.IsLoopback() || a.IsUnspecified() ||
+		a.IsLinkLocalUnicast() || a.IsLinkLocalMulticast() ||
+		a.IsInterfaceLocalMulticast() || sharedRange.Contains(a)
+}
+
+func automapped(q dnsmessage.Question, a netip.Addr) bool {
+	if !automapRange.Contains(a.Unmap()) {
+		return false
+	}
+	name := strings.ToLower(q.Name.String())
+	for _, s := range automapSuffix {
+		if strings.HasSuffix(name, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// filterRebind applies the rebinding filter to a response from tor.
+// It returns resp unchanged when nothing matched, a copy without the
+// offending A/AAAA records (strip) or a REFUSED answer (refuse).
+func filterRebind(hdr dnsmessage.Header, q dnsmessage.Question, resp []byte) ([]byte, error) {
+	if rebindMode == config.RebindOff {
+		return resp, nil
+	}
+	var m dnsmessage.Message
+	if err := m.Unpack(resp); err != nil {
+		return nil, err
+	}
+
+	blocked := 0
+	keep := func(rs []dnsmessage.Resource) []dnsmessage.Resource {
+		out := rs[:0]
+		for _, r := range rs {
+			var a netip.Addr
+			switch b := r.Body.(type) {
+			case *dnsmessage.AResource:
+				a = netip.AddrFrom4(b.A)
+			case *dnsmessage.AAAAResource:
+				a = netip.AddrFrom16(b.AAAA)
+			}
+			if a.IsValid() && internalAddr(a) && !automapped(q, a) {
+				blocked++
+				continue
+			}
+			out = append(out, r)
+		}
+		return out
+	}
+	m.Answers = keep(m.Answers)
+	m.Additionals = keep(m.Additionals)
+
+	if blocked == 0 {
+		return resp, nil
+	}
+	atomic.AddUint64(&rebindBlocked, uint64(blocked))
+	wipe(resp)
+
+	if rebindMode == config.RebindRefuse {
+		return rcodeReply(hdr, &q, dnsmessage.RCodeRefused)
+	}
+	return m.Pack()
+}
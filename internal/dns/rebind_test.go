@@ -0,0 +1,133 @@
+package dns
+
+import (
+	"net/netip"
+	"slices"
+	"testing"
+
+	"golang.org/x/net/dns/dnsmessage"
+
+	"torgo/internal/config"
+)
+
+// answer packs a response to name carrying one A or AAAA record per addr.
+func answer(t *testing.T, name string, addrs ...string) (dnsmessage.Header, dnsmessage.Question, []byte) {
+	t.Helper()
+	hdr := dnsmessage.Header{ID: 0x1234, Response: true, RecursionDesired: true}
+	q := dnsmessage.Question{Name: dnsmessage.MustNewName(name), Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET}
+	b := dnsmessage.NewBuilder(nil, hdr)
+	if err := b.StartQuestions(); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Question(q); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.StartAnswers(); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range addrs {
+		a := netip.MustParseAddr(s)
+		rh := dnsmessage.ResourceHeader{Name: q.Name, Class: dnsmessage.ClassINET, TTL: 60}
+		var err error
+		if a.Is4() {
+			err = b.AResource(rh, dnsmessage.AResource{A: a.As4()})
+		} else {
+			err = b.AAAAResource(rh, dnsmessage.AAAAResource{AAAA: a.As16()})
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	msg, err := b.Finish()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hdr, q, msg
+}
+
+// addrsOf returns the A/AAAA answers in msg.
+func addrsOf(t *testing.T, msg []byte) (dnsmessage.RCode, []string) {
+	t.Helper()
+	var m dnsmessage.Message
+	if err := m.Unpack(msg); err != nil {
+		t.Fatal(err)
+	}
+	var out []string
+	for _, r := range m.Answers {
+		switch b := r.Body.(type) {
+		case *dnsmessage.AResource:
+			out = append(out, netip.AddrFrom4(b.A).String())
+		case *dnsmessage.AAAAResource:
+			out = append(out, netip.AddrFrom16(b.AAAA).String())
+		}
+	}
+	return m.Header.RCode, out
+}
+
+func TestFilterRebind(t *testing.T) {
+	tests := []struct {
+		name  string
+		mode  string
+		qname string
+		addrs []string
+		rcode dnsmessage.RCode
+		want  []string
+	}{
+		{"off passes everything", config.RebindOff, "evil.example.", []string{"192.168.1.1", "127.0.0.1"},
+			dnsmessage.RCodeSuccess, []string{"192.168.1.1", "127.0.0.1"}},
+		{"public untouched", config.RebindStrip, "example.com.", []string{"93.184.216.34", "2606:2800:220:1::"},
+			dnsmessage.RCodeSuccess, []string{"93.184.216.34", "2606:2800:220:1::"}},
+
+		{"strip rfc1918", config.RebindStrip, "evil.example.", []string{"93.184.216.34", "10.0.0.1", "172.16.5.5", "192.168.1.1"},
+			dnsmessage.RCodeSuccess, []string{"93.184.216.34"}},
+		{"strip loopback", config.RebindStrip, "evil.example.", []string{"127.0.0.1", "::1"},
+			dnsmessage.RCodeSuccess, nil},
+		{"strip unspecified", config.RebindStrip, "evil.example.", []string{"0.0.0.0", "::"},
+			dnsmessage.RCodeSuccess, nil},
+		{"strip link-local", config.RebindStrip, "evil.example.", []string{"169.254.169.254", "fe80::1"},
+			dnsmessage.RCodeSuccess, nil},
+		{"strip ula", config.RebindStrip, "evil.example.", []string{"fd00::1"},
+			dnsmessage.RCodeSuccess, nil},
+		{"strip cgnat", config.RebindStrip, "evil.example.", []string{"100.64.0.1"},
+			dnsmessage.RCodeSuccess, nil},
+		{"strip v4-mapped", config.RebindStrip, "evil.example.", []string{"::ffff:192.168.1.1"},
+			dnsmessage.RCodeSuccess, nil},
+
+		{"onion automap exempt", config.RebindStrip, "abc.onion.", []string{"10.192.0.5"},
+			dnsmessage.RCodeSuccess, []string{"10.192.0.5"}},
+		{"exit automap exempt", config.RebindStrip, "host.relay.EXIT.", []string{"10.255.255.254"},
+			dnsmessage.RCodeSuccess, []string{"10.255.255.254"}},
+		{"onion outside automap range", config.RebindStrip, "abc.onion.", []string{"10.0.0.1"},
+			dnsmessage.RCodeSuccess, nil},
+		{"automap range on a public name", config.RebindStrip, "evil.example.", []string{"10.192.0.5"},
+			dnsmessage.RCodeSuccess, nil},
+		{"onion lookalike suffix", config.RebindStrip, "evil.notonion.", []string{"10.192.0.5"},
+			dnsmessage.RCodeSuccess, nil},
+
+		{"refuse", config.RebindRefuse, "evil.example.", []string{"93.184.216.34", "192.168.1.1"},
+			dnsmessage.RCodeRefused, nil},
+		{"refuse leaves public alone", config.RebindRefuse, "example.com.", []string{"93.184.216.34"},
+			dnsmessage.RCodeSuccess, []string{"93.184.216.34"}},
+		{"refuse exempts automap", config.RebindRefuse, "abc.onion.", []string{"10.192.0.5"},
+			dnsmessage.RCodeSuccess, []string{"10.192.0.5"}},
+	}
+
+	defer func(m string) { rebindMode = m }(rebindMode)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rebindMode = tt.mode
+			hdr, q, resp := answer(t, tt.qname, tt.addrs...)
+			out, err := filterRebind(hdr, q, resp)
+			if err != nil {
+				t.Fatal(err)
+			}
+			rcode, got := addrsOf(t, out)
+			if rcode != tt.rcode {
+				t.Errorf("rcode = %v, want %v", rcode, tt.rcode)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("answers = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
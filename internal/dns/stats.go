@@ -0,0 +1,34 @@
+package dns
+
+import "sync/atomic"
+
+// Stats is a point-in-time view of the DNS frontends. Counts only: no
+// names, answers or client addresses.
+type Stats struct {
+	Active      uint32     // TCP/DoT connections + UDP/DoH queries in flight
+	DoTActive   uint32     // DoT connections
+	PerInstance [32]uint32 // queries in flight per pool slot
+
+	CacheEntries  int
+	CacheHits     uint64
+	CacheMisses   uint64
+	RebindBlocked uint64 // answer records stripped or refused
+}
+
+// Snapshot returns the current DNS stats; see also BlocklistHits.
+func Snapshot() Stats {
+	s := Stats{
+		Active:        atomic.LoadUint32(&totalDNSConns),
+		DoTActive:     atomic.LoadUint32(&totalDoTConns),
+		CacheHits:     atomic.LoadUint64(&cacheHits),
+		CacheMisses:   atomic.LoadUint64(&cacheMisses),
+		RebindBlocked: atomic.LoadUint64(&rebindBlocked),
+	}
+	for i := range s.PerInstance {
+		s.PerInstance[i] = atomic.LoadUint32(&perInstDNSConns[i])
+	}
+	if c := cache; c != nil {
+		s.CacheEntries = c.len()
+	}
+	return s
+}
@@ -0,0 +1,95 @@
+package dns
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"net"
+	"sync"
+	"time"
+
+	"torgo/internal/config"
+)
+
+// Shared by the DoH and DoT frontends: one certificate per process.
+var (
+	tlsOnce sync.Once
+	tlsConf *tls.Config
+	tlsErr  error
+)
+
+// serverTLS returns the TLS config for the encrypted DNS frontends: the
+// configured cert/key pair (TORGO_DNS_TLS_CERT / TORGO_DNS_TLS_KEY) or,
+// failing that, a throwaway self-signed certificate. The self-signed key
+// never touches disk; its fingerprint is logged so clients can pin it.
+func serverTLS(cfg *config.Config) (*tls.Config, error) {
+	tlsOnce.Do(func() {
+		var cert tls.Certificate
+		switch {
+		case cfg.DNSTLSCert != "" && cfg.DNSTLSKey != "":
+			cert, tlsErr = tls.LoadX509KeyPair(cfg.DNSTLSCert, cfg.DNSTLSKey)
+			if tlsErr != nil {
+				tlsErr = fmt.Errorf("load dns tls keypair: %w", tlsErr)
+				return
+			}
+		case cfg.DNSTLSCert != "" || cfg.DNSTLSKey != "":
+			tlsErr = fmt.Errorf("dns tls: both TORGO_DNS_TLS_CERT and TORGO_DNS_TLS_KEY are required")
+			return
+		default:
+			cert, tlsErr = selfSigned()
+			if tlsErr != nil {
+				tlsErr = fmt.Errorf("generate self-signed dns certificate: %w", tlsErr)
+				return
+			}
+			sum := sha256.Sum256(cert.Certificate[0])
+			slog.Warn("dns tls using self-signed certificate",
+				"sha256", hex.EncodeToString(sum[:]),
+			)
+		}
+		tlsConf = &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			MinVersion:   tls.VersionTLS12,
+		}
+	})
+	if tlsErr != nil {
+		return nil, tlsErr
+	}
+	return tlsConf.Clone(), nil
+}
+
+func selfSigned() (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	now := time.Now()
+	tmpl := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "torgo"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.AddDate(1, 0, 0),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, nil
+}
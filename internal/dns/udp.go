@@ -0,0 +1,300 @@
+package dns
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+
+	"torgo/internal/config"
+	"torgo/internal/registry"
+)
+
+const (
+	// Classic DNS-over-UDP ceiling without EDNS (RFC 1035 §4.2.1)
+	udpMinSize = 512
+	// Largest EDNS payload we honour; bigger answers get TC and a TCP retry
+	udpMaxSize = 4096
+	// tor's resolver round-trips a circuit; stub resolvers retry well before this
+	dnsQueryTimeout = 5 * time.Second
+)
+
+// serveUDP answers DNS-over-UDP on the same address as the TCP listener.
+// Unlike TCP there is no connection to pin: every query picks its own
+// instance and holds that instance's slot only while it is in flight.
+// maxInflight > 0 caps this listener's queries in flight.
+func serveUDP(ctx context.Context, addr string, insts []*config.Instance, maxInflight int) {
+	pc, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		slog.Error("dns udp bind failed", "err", err)
+		return
+	}
+	defer pc.Close()
+	go func() {
+		<-ctx.Done()
+		_ = pc.Close()
+	}()
+	slog.Info("DNS-over-UDP proxy active", "addr", pc.LocalAddr())
+
+	var active int32
+	buf := make([]byte, udpMaxSize)
+	for {
+		n, from, err := pc.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue
+		}
+
+		// Global limit check (shared with TCP), then the listener's own:
+		// drop, the stub will retry
+		if atomic.LoadUint32(&totalDNSConns) >= dnsMaxConns ||
+			(maxInflight > 0 && atomic.LoadInt32(&active) >= int32(maxInflight)) {
+			wipe(buf[:n])
+			continue
+		}
+		atomic.AddUint32(&totalDNSConns, 1)
+		atomic.AddInt32(&active, 1)
+
+		q := make([]byte, n)
+		copy(q, buf[:n])
+		wipe(buf[:n])
+		go func() {
+			defer atomic.AddInt32(&active, -1)
+			handleUDP(pc, from, q, insts)
+		}()
+	}
+}
+
+func handleUDP(pc net.PacketConn, from net.Addr, q []byte, insts []*config.Instance) {
+	// 1. PANIC RECOVERY
+	defer func() {
+		if r := recover(); r != nil {
+			slog.Error("dns udp panic recovered", "err", r)
+		}
+	}()
+
+	defer atomic.AddUint32(&totalDNSConns, ^uint32(0))
+	defer wipe(q)
+
+	// Malformed or non-query packets never reach tor and get no answer
+	hdr, question, limit, err := parseQuery(q)
+	if err != nil {
+		return
+	}
+
+	resp, err := resolve(insts, clientHost(from), q, hdr, question)
+	if err != nil {
+		// SERVFAIL rather than silence, so the stub fails over instead of
+		// sitting out its whole timeout
+		if resp, err = rcodeReply(hdr, &question, dnsmessage.RCodeServerFailure); err != nil {
+			return
+		}
+	}
+	defer wipe(resp)
+
+	out := resp
+	if len(resp) > limit {
+		if out, err = truncated(hdr, question, resp); err != nil {
+			return
+		}
+	}
+	_, _ = pc.WriteTo(out, from)
+}
+
+// exchange sends one DNS message to a tor DNSPort over UDP (tor's DNSPort
+// speaks nothing else) and returns the matching response. Shared by the UDP
+// and TCP frontends, so every query is balanced on its own. Refused
+// instances are failed over to the next best one; a timeout is not retried
+// (tor is resolving, the client will ask again).
+func exchange(insts []*config.Instance, q []byte) ([]byte, error) {
+	instCount := len(insts)
+	if instCount > 32 {
+		instCount = 32
+	}
+	if instCount == 0 || len(q) < 12 {
+		return nil, errors.New("dns: nothing to exchange")
+	}
+
+	// tor never sees the client's query ID: a fresh random one goes out and
+	// the original is put back on the way in
+	orig := [2]byte{q[0], q[1]}
+	defer func() { q[0], q[1] = orig[0], orig[1] }()
+	if _, err := rand.Read(q[:2]); err != nil {
+		return nil, err
+	}
+
+	var tried uint32 // bitmask of slots already attempted
+	for attempt := 0; attempt < maxDialAttempts; attempt++ {
+		idx := pickInstance(insts, instCount, tried)
+		if idx < 0 {
+			return nil, errors.New("dns: no usable instance")
+		}
+		tried |= 1 << uint(idx)
+
+		if atomic.AddUint32(&perInstDNSConns[idx], 1) > dnsMaxPerInstance {
+			atomic.AddUint32(&perInstDNSConns[idx], ^uint32(0))
+			continue
+		}
+		resp, err := exchangeWith(insts[idx], q)
+		atomic.AddUint32(&perInstDNSConns[idx], ^uint32(0))
+
+		// Only an outright refusal says anything about the instance itself
+		var derr error
+		if errors.Is(err, syscall.ECONNREFUSED) {
+			derr = err
+		}
+		if registry.RecordDNS(idx, derr) {
+			slog.Warn("tor instance refusing dns — pulled from pool", "id", insts[idx].ID)
+		}
+		if err == nil {
+			resp[0], resp[1] = orig[0], orig[1]
+			return resp, nil
+		}
+		if derr == nil {
+			return nil, err
+		}
+	}
+	return nil, errors.New("dns: all attempts failed")
+}
+
+func exchangeWith(inst *config.Instance, q []byte) ([]byte, error) {
+	// FIX: Use fmt.Sprintf
+	addr := fmt.Sprintf("127.0.0.1:%d", inst.DNSPort)
+
+	// A fresh connected socket per query: random source port, and the
+	// kernel drops datagrams from anyone but tor's DNSPort
+	conn, err := net.DialTimeout("udp", addr, dnsDialTimeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(dnsQueryTimeout))
+
+	if _, err := conn.Write(q); err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, 65535)
+	defer wipe(buf)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+		// Ignore anything that isn't the response to this query
+		if n < 12 || buf[0] != q[0] || buf[1] != q[1] || buf[2]&0x80 == 0 {
+			continue
+		}
+		resp := make([]byte, n)
+		copy(resp, buf[:n])
+		return resp, nil
+	}
+}
+
+// parseQuery validates a client query and returns its header, its (single)
+// question and the largest UDP answer the client accepts.
+func parseQuery(q []byte) (dnsmessage.Header, dnsmessage.Question, int, error) {
+	var p dnsmessage.Parser
+	hdr, err := p.Start(q)
+	if err != nil {
+		return hdr, dnsmessage.Question{}, 0, err
+	}
+	if hdr.Response {
+		return hdr, dnsmessage.Question{}, 0, errors.New("dns: not a query")
+	}
+	qs, err := p.AllQuestions()
+	if err != nil {
+		return hdr, dnsmessage.Question{}, 0, err
+	}
+	if len(qs) != 1 {
+		return hdr, dnsmessage.Question{}, 0, errors.New("dns: want exactly one question")
+	}
+	if err := p.SkipAllAnswers(); err != nil {
+		return hdr, qs[0], 0, err
+	}
+	if err := p.SkipAllAuthorities(); err != nil {
+		return hdr, qs[0], 0, err
+	}
+
+	// EDNS0: the OPT record's CLASS carries the client's UDP payload size
+	limit := udpMinSize
+	for {
+		rh, err := p.AdditionalHeader()
+		if err == dnsmessage.ErrSectionDone {
+			break
+		}
+		if err != nil {
+			return hdr, qs[0], 0, err
+		}
+		if rh.Type == dnsmessage.TypeOPT {
+			limit = int(rh.Class)
+		}
+		if err := p.SkipAdditional(); err != nil {
+			return hdr, qs[0], 0, err
+		}
+	}
+	if limit < udpMinSize {
+		limit = udpMinSize
+	}
+	if limit > udpMaxSize {
+		limit = udpMaxSize
+	}
+	return hdr, qs[0], limit, nil
+}
+
+// truncated turns an oversized answer into header + question with TC set,
+// telling the client to retry over TCP (RFC 7766 §5).
+func truncated(query dnsmessage.Header, q dnsmessage.Question, resp []byte) ([]byte, error) {
+	var p dnsmessage.Parser
+	hdr, err := p.Start(resp)
+	if err != nil {
+		return nil, err
+	}
+	hdr.ID = query.ID
+	hdr.Truncated = true
+
+	b := dnsmessage.NewBuilder(make([]byte, 0, udpMinSize), hdr)
+	if err := b.StartQuestions(); err != nil {
+		return nil, err
+	}
+	if err := b.Question(q); err != nil {
+		return nil, err
+	}
+	return b.Finish()
+}
+
+// rcodeReply builds an answerless response to query carrying rcode.
+func rcodeReply(query dnsmessage.Header, q *dnsmessage.Question, rcode dnsmessage.RCode) ([]byte, error) {
+	hdr := dnsmessage.Header{
+		ID:               query.ID,
+		Response:         true,
+		OpCode:           query.OpCode,
+		RecursionDesired: query.RecursionDesired,
+		RCode:            rcode,
+	}
+	b := dnsmessage.NewBuilder(make([]byte, 0, udpMinSize), hdr)
+	if q != nil {
+		if err := b.StartQuestions(); err != nil {
+			return nil, err
+		}
+		if err := b.Question(*q); err != nil {
+			return nil, err
+		}
+	}
+	return b.Finish()
+}
+
+func wipe(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
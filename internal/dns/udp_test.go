@@ -0,0 +1,109 @@
+package dns
+
+import (
+	"testing"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// query packs a client query, with an OPT record advertising edns when > 0.
+func query(t *testing.T, names []string, response bool, edns uint16) []byte {
+	t.Helper()
+	m := dnsmessage.Message{Header: dnsmessage.Header{ID: 42, Response: response, RecursionDesired: true}}
+	for _, n := range names {
+		m.Questions = append(m.Questions, question(n))
+	}
+	if edns > 0 {
+		var opt dnsmessage.ResourceHeader
+		if err := opt.SetEDNS0(int(edns), dnsmessage.RCodeSuccess, false); err != nil {
+			t.Fatal(err)
+		}
+		m.Additionals = append(m.Additionals, dnsmessage.Resource{Header: opt, Body: &dnsmessage.OPTResource{}})
+	}
+	msg, err := m.Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return msg
+}
+
+func TestParseQuery(t *testing.T) {
+	tests := []struct {
+		name     string
+		msg      []byte
+		wantErr  bool
+		wantSize int
+	}{
+		{name: "plain", msg: query(t, []string{"example.com."}, false, 0), wantSize: udpMinSize},
+		{name: "edns size", msg: query(t, []string{"example.com."}, false, 1232), wantSize: 1232},
+		{name: "edns below minimum", msg: query(t, []string{"example.com."}, false, 256), wantSize: udpMinSize},
+		{name: "edns above maximum", msg: query(t, []string{"example.com."}, false, 65000), wantSize: udpMaxSize},
+		{name: "response", msg: query(t, []string{"example.com."}, true, 0), wantErr: true},
+		{name: "no question", msg: query(t, nil, false, 0), wantErr: true},
+		{name: "two questions", msg: query(t, []string{"a.example.", "b.example."}, false, 0), wantErr: true},
+		{name: "short", msg: []byte{0, 1, 0}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hdr, q, size, err := parseQuery(tt.msg)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("parsed, want error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if hdr.ID != 42 || q.Name.String() != "example.com." || size != tt.wantSize {
+				t.Errorf("got id %d %s size %d, want 42 example.com. %d", hdr.ID, q.Name, size, tt.wantSize)
+			}
+		})
+	}
+}
+
+func TestTruncated(t *testing.T) {
+	q := question("example.com.")
+	out, err := truncated(dnsmessage.Header{ID: 9}, q, response(t, "example.com.", 0, false, 300, 300, 300))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var m dnsmessage.Message
+	if err := m.Unpack(out); err != nil {
+		t.Fatal(err)
+	}
+	if m.ID != 9 || !m.Truncated || len(m.Questions) != 1 || len(m.Answers) != 0 {
+		t.Errorf("got %+v, %d questions, %d answers; want ID 9, TC, 1 question, no answers",
+			m.Header, len(m.Questions), len(m.Answers))
+	}
+}
+
+func TestRcodeReply(t *testing.T) {
+	q := question("example.com.")
+	hdr := dnsmessage.Header{ID: 5, RecursionDesired: true}
+	for _, tt := range []struct {
+		q     *dnsmessage.Question
+		rcode dnsmessage.RCode
+	}{
+		{&q, dnsmessage.RCodeServerFailure},
+		{&q, dnsmessage.RCodeRefused},
+		{nil, dnsmessage.RCodeFormatError},
+	} {
+		out, err := rcodeReply(hdr, tt.q, tt.rcode)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var m dnsmessage.Message
+		if err := m.Unpack(out); err != nil {
+			t.Fatal(err)
+		}
+		wantQs := 1
+		if tt.q == nil {
+			wantQs = 0
+		}
+		if m.ID != 5 || !m.Response || !m.RecursionDesired || m.RCode != tt.rcode || len(m.Questions) != wantQs {
+			t.Errorf("%v: got %+v with %d questions", tt.rcode, m.Header, len(m.Questions))
+		}
+	}
+}
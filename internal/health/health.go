@@ -1,4 +1,4 @@
-// internal/health/health.go — MONITOR ONLY (NO HEALING)
+// internal/health/health.go — MONITOR (HEALING IS OPT-IN, SEE restart.go)
 package health
 
 import (
@@ -6,33 +6,63 @@ import (
 	"fmt"
 	"log/slog"
 	"net"
+	"sync"
 	"sync/atomic"
 	"time"
 
 	"torgo/internal/config"
+	"torgo/internal/registry"
 )
 
-// Per-instance state
+// Per-instance monitor state. The healthy/failed verdicts themselves live in
+// the shared registry so the socks and dns pickers can consult them.
 type instanceState struct {
-	healthy  uint32    // 1 = healthy, 0 = dead
 	lastSeen time.Time
+	misses   uint32 // consecutive failed probes (atomic: read by metrics)
+	restarts uint64 // successful heals (atomic)
+
+	// restart bookkeeping (restart.go)
+	mu         sync.Mutex
+	restarting uint32      // 1 = restart scheduled/in flight
+	attempts   int         // consecutive restarts without a healthy probe (backoff exponent)
+	history    []time.Time // restarts inside the current window
 }
 
 var states [32]*instanceState
 
 func init() {
 	for i := range states {
-		states[i] = &instanceState{healthy: 1, lastSeen: time.Now()}
+		states[i] = &instanceState{lastSeen: time.Now()}
 	}
 }
 
+// ProbeMisses returns the consecutive failed probes for slot idx.
+func ProbeMisses(idx int) uint32 {
+	if idx < 0 || idx >= len(states) {
+		return 0
+	}
+	return atomic.LoadUint32(&states[idx].misses)
+}
+
+// Restarts returns how many times health has restarted slot idx.
+func Restarts(idx int) uint64 {
+	if idx < 0 || idx >= len(states) {
+		return 0
+	}
+	return atomic.LoadUint64(&states[idx].restarts)
+}
+
 // CheckSocks performs a strict SOCKS5 handshake.
 // Shared by main.go and selfcheck.go.
 func CheckSocks(port int) error {
-	addr := fmt.Sprintf("127.0.0.1:%d", port)
+	return CheckSocksAddr("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+}
+
+// CheckSocksAddr is CheckSocks for any listener (TCP address or Unix socket).
+func CheckSocksAddr(network, addr string) error {
 	timeout := 1 * time.Second
 
-	conn, err := net.DialTimeout("tcp", addr, timeout)
+	conn, err := net.DialTimeout(network, addr, timeout)
 	if err != nil {
 		return err
 	}
@@ -58,36 +88,126 @@ func CheckSocks(port int) error {
 	return nil
 }
 
-func Monitor(ctx context.Context, insts []*config.Instance) {
+// Monitor probes every instance and applies the restart policy. exits must
+// come from config.SubscribeExits before the instances were started, so
+// children that die while bootstrapping are not missed.
+func Monitor(ctx context.Context, insts []*config.Instance, cfg *config.Config, exits <-chan config.ExitEvent) {
 	ticker := time.NewTicker(15 * time.Second)
 	defer ticker.Stop()
 
+	h := newHealer(ctx, cfg)
+
+	// Supervisor exits arrive immediately instead of at the next 15 s probe
+	byID := make(map[int]int, len(insts))
+	for idx, inst := range insts {
+		byID[inst.ID] = idx
+	}
+
 	for {
 		select {
 		case <-ctx.Done():
 			return
+		case ev := <-exits:
+			idx, ok := byID[ev.ID]
+			if !ok || ev.Expected || idx >= len(states) {
+				continue
+			}
+			if registry.SetHealthy(idx, false) && !h.enabled() {
+				slog.Error("tor instance died — manual intervention required",
+					"id", ev.ID,
+					"code", ev.Code,
+					"signal", ev.Signal,
+				)
+			}
+			if h.wantsExitRestart(ev) {
+				h.schedule(insts[idx], idx, "exited")
+			}
 		case <-ticker.C:
 			for idx, inst := range insts {
-				checkInstance(inst, idx)
+				checkInstance(h, inst, idx)
 			}
 		}
 	}
 }
 
-func checkInstance(inst *config.Instance, idx int) {
+func checkInstance(h *healer, inst *config.Instance, idx int) {
 	state := states[idx]
 
+	// Dead processes: normally handled from the exit event; this catches any
+	// exit that was missed (e.g. dropped by a full subscriber channel) or a
+	// tor that never started
+	if !inst.Running() {
+		registry.SetHealthy(idx, false)
+		ev, exited := inst.LastExit()
+		if h.enabled() && (!exited || (!ev.Expected && h.wantsExitRestart(ev))) {
+			h.schedule(inst, idx, "not running")
+		}
+		return
+	}
+
 	// Try strict check
 	if err := CheckSocks(inst.SocksPort); err == nil {
-		if atomic.SwapUint32(&state.healthy, 1) == 0 {
-			slog.Info("tor instance recovered (externally)", "id", inst.ID)
+		if !registry.SetHealthy(idx, true) {
+			slog.Info("tor instance recovered", "id", inst.ID)
+		}
+		if registry.DNSDown(idx) && CheckDNS(inst.DNSPort) == nil {
+			registry.SetDNSHealthy(idx, true)
+			slog.Info("tor instance dns recovered", "id", inst.ID)
 		}
 		state.lastSeen = time.Now()
+		atomic.StoreUint32(&state.misses, 0)
+		if inst.Bootstrapped() {
+			h.settled(idx)
+		}
 		return
 	}
 
-	// Mark as unhealthy, but DO NOT RESTART (No Guard Rotation)
-	if atomic.SwapUint32(&state.healthy, 0) == 1 {
-		slog.Error("tor instance unresponsive — manual intervention required", "id", inst.ID)
+	misses := atomic.AddUint32(&state.misses, 1)
+
+	if !h.enabled() {
+		// Mark as unhealthy, but DO NOT RESTART (No Guard Rotation)
+		if registry.SetHealthy(idx, false) {
+			slog.Error("tor instance unresponsive — manual intervention required", "id", inst.ID)
+		}
+		return
 	}
-}
\ No newline at end of file
+
+	if registry.SetHealthy(idx, false) {
+		slog.Error("tor instance unresponsive", "id", inst.ID)
+	}
+	// Alive but wedged: only heal after several misses so a concurrent
+	// hard rotation (which briefly closes the port) isn't mistaken for a hang
+	if misses >= unresponsiveMisses {
+		h.schedule(inst, idx, "unresponsive")
+	}
+}
+
+// CheckDNS probes a tor DNSPort for the one failure the dns frontend acts on:
+// an outright refusal. A query for the root is sent on a connected UDP socket;
+// an ICMP port-unreachable surfaces as ECONNREFUSED on read, while an answer
+// or a timeout (tor still resolving) both mean something is listening.
+func CheckDNS(port int) error {
+	timeout := 1 * time.Second
+
+	conn, err := net.DialTimeout("udp", fmt.Sprintf("127.0.0.1:%d", port), timeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_ = conn.SetDeadline(time.Now().Add(timeout))
+
+	// ID 0, RD set, one question: ". IN NS"
+	q := []byte{0, 0, 0x01, 0x00, 0, 1, 0, 0, 0, 0, 0, 0, 0x00, 0, 2, 0, 1}
+	if _, err := conn.Write(q); err != nil {
+		return fmt.Errorf("write failed: %w", err)
+	}
+	buf := make([]byte, 512)
+	if _, err := conn.Read(buf); err != nil {
+		if ne, ok := err.(net.Error); ok && ne.Timeout() {
+			return nil
+		}
+		return fmt.Errorf("read failed: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,135 @@
+package health
+
+import (
+	"context"
+	"log/slog"
+	"sync/atomic"
+	"time"
+
+	"torgo/internal/config"
+	"torgo/internal/registry"
+)
+
+// Consecutive failed probes before a live-but-wedged instance is restarted.
+const unresponsiveMisses = 3
+
+// healer implements the opt-in restart policy (TORGO_RESTART_POLICY).
+// Every restart goes through Instance.Restart; a crash-looping instance is
+// backed off exponentially and, once it burns its per-window budget, is
+// marked permanently failed (circuit breaker) and left down.
+type healer struct {
+	ctx        context.Context
+	policy     string
+	backoffMin time.Duration
+	backoffMax time.Duration
+	maxRestart int
+	window     time.Duration
+}
+
+func newHealer(ctx context.Context, cfg *config.Config) *healer {
+	return &healer{
+		ctx:        ctx,
+		policy:     cfg.RestartPolicy,
+		backoffMin: time.Duration(cfg.RestartBackoffMinSecs) * time.Second,
+		backoffMax: time.Duration(cfg.RestartBackoffMaxSecs) * time.Second,
+		maxRestart: cfg.RestartMaxPerWindow,
+		window:     time.Duration(cfg.RestartWindowSecs) * time.Second,
+	}
+}
+
+func (h *healer) enabled() bool { return h.policy != config.RestartNever }
+
+// wantsExitRestart applies the policy to an unexpected exit.
+func (h *healer) wantsExitRestart(ev config.ExitEvent) bool {
+	switch h.policy {
+	case config.RestartAlways:
+		return true
+	case config.RestartOnFailure:
+		return ev.Code != 0 || ev.Signal != ""
+	}
+	return false
+}
+
+// settled resets the backoff once an instance is healthy and bootstrapped again.
+func (h *healer) settled(idx int) {
+	st := states[idx]
+	st.mu.Lock()
+	st.attempts = 0
+	st.mu.Unlock()
+}
+
+func (h *healer) schedule(inst *config.Instance, idx int, reason string) {
+	st := states[idx]
+	if registry.Failed(idx) {
+		return
+	}
+	if !atomic.CompareAndSwapUint32(&st.restarting, 0, 1) {
+		return // already queued
+	}
+	go h.restartLoop(inst, idx, reason)
+}
+
+func (h *healer) restartLoop(inst *config.Instance, idx int, reason string) {
+	st := states[idx]
+	defer atomic.StoreUint32(&st.restarting, 0)
+
+	for {
+		delay, ok := h.reserve(st)
+		if !ok {
+			registry.SetFailed(idx)
+			slog.Error("tor instance permanently failed — restart budget exhausted",
+				"id", inst.ID,
+				"maxRestarts", h.maxRestart,
+				"window", h.window,
+			)
+			return
+		}
+
+		slog.Warn("restarting tor instance", "id", inst.ID, "reason", reason, "in", delay)
+		select {
+		case <-h.ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+
+		err := inst.Restart()
+		if err == nil {
+			atomic.AddUint64(&st.restarts, 1)
+			slog.Info("tor instance restarted", "id", inst.ID)
+			return
+		}
+		slog.Error("tor instance restart failed", "id", inst.ID, "err", err)
+		reason = "restart failed"
+	}
+}
+
+// reserve books one restart against the window budget and returns the
+// backoff to wait first. ok=false means the breaker should trip.
+func (h *healer) reserve(st *instanceState) (time.Duration, bool) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	now := time.Now()
+	kept := st.history[:0]
+	for _, t := range st.history {
+		if now.Sub(t) < h.window {
+			kept = append(kept, t)
+		}
+	}
+	st.history = kept
+
+	if h.maxRestart > 0 && len(st.history) >= h.maxRestart {
+		return 0, false
+	}
+	st.history = append(st.history, now)
+
+	delay := h.backoffMin
+	for n := 0; n < st.attempts && delay < h.backoffMax; n++ {
+		delay *= 2
+	}
+	if delay > h.backoffMax {
+		delay = h.backoffMax
+	}
+	st.attempts++
+	return delay, true
+}
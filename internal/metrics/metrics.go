@@ -0,0 +1,184 @@
+// internal/metrics/metrics.go — PROMETHEUS EXPORT (COUNTS ONLY, NEVER DESTINATIONS)
+package metrics
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"torgo/internal/config"
+	"torgo/internal/dns"
+	"torgo/internal/health"
+	"torgo/internal/registry"
+	"torgo/internal/socks"
+)
+
+// Start serves /metrics on cfg.MetricsAddr (TORGO_METRICS_ADDR) in the
+// Prometheus text exposition format. Off unless configured. Everything is
+// read from the packages' atomic counters at scrape time; labels are
+// instance IDs, tiers, reject reasons and blocklist file names only.
+func Start(ctx context.Context, insts []*config.Instance, cfg *config.Config) {
+	if cfg.MetricsAddr == "" {
+		return
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		bw := bufio.NewWriter(w)
+		write(bw, insts)
+		_ = bw.Flush()
+	})
+
+	srv := &http.Server{
+		Addr:              cfg.MetricsAddr,
+		Handler:           mux,
+		ReadHeaderTimeout: 5 * time.Second,
+		WriteTimeout:      10 * time.Second,
+		IdleTimeout:       60 * time.Second,
+		MaxHeaderBytes:    8 << 10,
+		ErrorLog:          log.New(io.Discard, "", 0),
+	}
+	go func() {
+		<-ctx.Done()
+		_ = srv.Close()
+	}()
+
+	slog.Info("metrics endpoint active", "addr", cfg.MetricsAddr)
+	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		slog.Error("metrics bind failed", "err", err)
+	}
+}
+
+// family writes the HELP/TYPE header for one metric family.
+func family(w io.Writer, name, typ, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+func b2f(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func write(w io.Writer, insts []*config.Instance) {
+	// 1. SOCKS / HTTP / TRANSPARENT FRONTENDS
+	family(w, "torgo_proxy_active_connections", "gauge", "Proxied connections open across all frontends.")
+	fmt.Fprintf(w, "torgo_proxy_active_connections %d\n", socks.ActiveConns())
+
+	rejects := socks.RejectCounts()
+	reasons := make([]string, 0, len(rejects))
+	for r := range rejects {
+		reasons = append(reasons, r)
+	}
+	sort.Strings(reasons)
+	family(w, "torgo_proxy_rejects_total", "counter", "Clients turned away by torgo, by reason.")
+	for _, r := range reasons {
+		fmt.Fprintf(w, "torgo_proxy_rejects_total{reason=%q} %d\n", r, rejects[r])
+	}
+
+	// 2. PER INSTANCE
+	stats := socks.Snapshot(insts)
+	ds := dns.Snapshot()
+	lbl := func(s socks.InstanceStats) string {
+		return "instance=\"" + strconv.Itoa(s.ID) + "\",tier=\"" + s.Tier + "\""
+	}
+
+	family(w, "torgo_instance_running", "gauge", "1 if the tor process is alive.")
+	for idx, s := range stats {
+		fmt.Fprintf(w, "torgo_instance_running{%s} %d\n", lbl(s), b2f(insts[idx].Running()))
+	}
+	family(w, "torgo_instance_bootstrap_percent", "gauge", "tor bootstrap progress (100 = ready).")
+	for idx, s := range stats {
+		pct, _ := insts[idx].Bootstrap()
+		fmt.Fprintf(w, "torgo_instance_bootstrap_percent{%s} %d\n", lbl(s), pct)
+	}
+	family(w, "torgo_instance_healthy", "gauge", "1 if the instance is eligible for selection.")
+	for idx, s := range stats {
+		fmt.Fprintf(w, "torgo_instance_healthy{%s} %d\n", lbl(s), b2f(registry.Healthy(idx)))
+	}
+	family(w, "torgo_instance_dns_healthy", "gauge", "1 if the instance's DNSPort is eligible for dns selection.")
+	for idx, s := range stats {
+		fmt.Fprintf(w, "torgo_instance_dns_healthy{%s} %d\n", lbl(s), b2f(registry.DNSHealthy(idx)))
+	}
+	family(w, "torgo_instance_failed", "gauge", "1 if the restart circuit breaker gave up on the instance.")
+	for idx, s := range stats {
+		fmt.Fprintf(w, "torgo_instance_failed{%s} %d\n", lbl(s), b2f(registry.Failed(idx)))
+	}
+	family(w, "torgo_instance_draining", "gauge", "1 while the instance drains before a hard rotation.")
+	for _, s := range stats {
+		fmt.Fprintf(w, "torgo_instance_draining{%s} %d\n", lbl(s), b2f(s.Draining))
+	}
+	family(w, "torgo_instance_active_connections", "gauge", "Proxied connections open on the instance.")
+	for _, s := range stats {
+		fmt.Fprintf(w, "torgo_instance_active_connections{%s} %d\n", lbl(s), s.Active)
+	}
+	family(w, "torgo_instance_connections_since_rotation", "gauge", "Connections since the instance last rotated.")
+	for _, s := range stats {
+		fmt.Fprintf(w, "torgo_instance_connections_since_rotation{%s} %d\n", lbl(s), s.SinceRotation)
+	}
+	family(w, "torgo_instance_rotations_total", "counter", "Rotations by mode (newnym = SIGNAL NEWNYM, restart = drain + restart).")
+	for _, s := range stats {
+		fmt.Fprintf(w, "torgo_instance_rotations_total{%s,mode=\"newnym\"} %d\n", lbl(s), s.SoftRotations)
+		fmt.Fprintf(w, "torgo_instance_rotations_total{%s,mode=\"restart\"} %d\n", lbl(s), s.HardRotations)
+	}
+	family(w, "torgo_instance_bytes_total", "counter", "Bytes relayed through the instance by direction.")
+	for _, s := range stats {
+		fmt.Fprintf(w, "torgo_instance_bytes_total{%s,direction=\"in\"} %d\n", lbl(s), s.BytesIn)
+		fmt.Fprintf(w, "torgo_instance_bytes_total{%s,direction=\"out\"} %d\n", lbl(s), s.BytesOut)
+	}
+	family(w, "torgo_instance_dial_failures_total", "counter", "Upstream dials to the instance that failed.")
+	for idx, s := range stats {
+		fmt.Fprintf(w, "torgo_instance_dial_failures_total{%s} %d\n", lbl(s), registry.DialFailures(idx))
+	}
+	family(w, "torgo_instance_probe_misses", "gauge", "Consecutive failed health probes.")
+	for idx, s := range stats {
+		fmt.Fprintf(w, "torgo_instance_probe_misses{%s} %d\n", lbl(s), health.ProbeMisses(idx))
+	}
+	family(w, "torgo_instance_restarts_total", "counter", "Restarts performed by the health restart policy.")
+	for idx, s := range stats {
+		fmt.Fprintf(w, "torgo_instance_restarts_total{%s} %d\n", lbl(s), health.Restarts(idx))
+	}
+	family(w, "torgo_instance_dns_active_queries", "gauge", "DNS queries in flight on the instance.")
+	for idx, s := range stats {
+		fmt.Fprintf(w, "torgo_instance_dns_active_queries{%s} %d\n", lbl(s), ds.PerInstance[idx])
+	}
+
+	// 3. DNS FRONTENDS
+	family(w, "torgo_dns_active", "gauge", "DNS connections and queries in flight across all frontends.")
+	fmt.Fprintf(w, "torgo_dns_active %d\n", ds.Active)
+	family(w, "torgo_dns_dot_active_connections", "gauge", "DNS-over-TLS connections open.")
+	fmt.Fprintf(w, "torgo_dns_dot_active_connections %d\n", ds.DoTActive)
+	family(w, "torgo_dns_cache_entries", "gauge", "Answers held in the DNS cache.")
+	fmt.Fprintf(w, "torgo_dns_cache_entries %d\n", ds.CacheEntries)
+	family(w, "torgo_dns_cache_hits_total", "counter", "Queries answered from the DNS cache.")
+	fmt.Fprintf(w, "torgo_dns_cache_hits_total %d\n", ds.CacheHits)
+	family(w, "torgo_dns_cache_misses_total", "counter", "Cacheable queries forwarded to tor.")
+	fmt.Fprintf(w, "torgo_dns_cache_misses_total %d\n", ds.CacheMisses)
+	family(w, "torgo_dns_rebind_blocked_total", "counter", "Answer records stripped or refused by the rebinding filter.")
+	fmt.Fprintf(w, "torgo_dns_rebind_blocked_total %d\n", ds.RebindBlocked)
+
+	hits := dns.BlocklistHits()
+	lists := make([]string, 0, len(hits))
+	for l := range hits {
+		lists = append(lists, l)
+	}
+	sort.Strings(lists)
+	family(w, "torgo_dns_blocklist_hits_total", "counter", "Queries answered locally by a blocklist.")
+	for _, l := range lists {
+		fmt.Fprintf(w, "torgo_dns_blocklist_hits_total{list=%q} %d\n", l, hits[l])
+	}
+}
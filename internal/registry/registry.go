@@ -0,0 +1,151 @@
+// internal/registry/registry.go — SHARED INSTANCE STATE (HEALTH WRITES, PICKERS READ)
+package registry
+
+import "sync/atomic"
+
+// Indexed like the socks/dns per-instance arrays (slot in the started
+// instance slice, not Instance.ID). Up to 32 instances.
+var (
+	healthy [32]uint32 // 1 = eligible for selection, 0 = unhealthy
+	failed  [32]uint32 // 1 = circuit breaker open, never eligible again
+	drained [32]uint32 // 1 = taken out of selection by the operator (admin API)
+
+	dialFailures [32]uint64 // upstream dials to tor refused/timed out (lifetime)
+	dialStreak   [32]uint32 // consecutive dial failures
+
+	// The DNSPort is a separate listener in tor: its refusals only take the
+	// instance out of DNS selection, never out of the SOCKS pool
+	dnsDown   [32]uint32 // 1 = DNSPort refusing, skipped by the dns picker
+	dnsStreak [32]uint32 // consecutive DNSPort refusals
+)
+
+// Consecutive dial failures before an instance is pulled from selection.
+// health.Monitor puts it back once its SOCKS (or DNS) port answers again.
+const dialFailuresUnhealthy = 3
+
+func init() {
+	for i := range healthy {
+		healthy[i] = 1
+	}
+}
+
+func valid(idx int) bool { return idx >= 0 && idx < len(healthy) }
+
+// Healthy reports whether pickers may route to instance idx.
+func Healthy(idx int) bool {
+	if !valid(idx) {
+		return false
+	}
+	return atomic.LoadUint32(&healthy[idx]) == 1 && atomic.LoadUint32(&failed[idx]) == 0
+}
+
+// SetHealthy updates the health flag and returns the previous value.
+func SetHealthy(idx int, ok bool) (was bool) {
+	if !valid(idx) {
+		return false
+	}
+	var v uint32
+	if ok {
+		v = 1
+		// A recovered instance starts with a clean slate, not one refusal
+		// away from being pulled again
+		atomic.StoreUint32(&dialStreak[idx], 0)
+	}
+	return atomic.SwapUint32(&healthy[idx], v) == 1
+}
+
+// Failed reports whether instance idx has been given up on for good.
+func Failed(idx int) bool {
+	return valid(idx) && atomic.LoadUint32(&failed[idx]) == 1
+}
+
+// SetFailed opens the circuit breaker for instance idx.
+func SetFailed(idx int) {
+	if valid(idx) {
+		atomic.StoreUint32(&failed[idx], 1)
+	}
+}
+
+// Drained reports whether the operator has taken instance idx out of selection.
+func Drained(idx int) bool {
+	return valid(idx) && atomic.LoadUint32(&drained[idx]) == 1
+}
+
+// SetDrained takes instance idx out of (or back into) selection. Unlike
+// rotation draining this never leads to a restart; it returns the previous value.
+func SetDrained(idx int, on bool) (was bool) {
+	if !valid(idx) {
+		return false
+	}
+	var v uint32
+	if on {
+		v = 1
+	}
+	return atomic.SwapUint32(&drained[idx], v) == 1
+}
+
+// RecordDial feeds the outcome of a frontend's upstream dial into the health
+// state. It returns true when this failure just marked the instance unhealthy.
+func RecordDial(idx int, err error) bool {
+	if !valid(idx) {
+		return false
+	}
+	if err == nil {
+		atomic.StoreUint32(&dialStreak[idx], 0)
+		return false
+	}
+	atomic.AddUint64(&dialFailures[idx], 1)
+	if atomic.AddUint32(&dialStreak[idx], 1) >= dialFailuresUnhealthy {
+		return SetHealthy(idx, false)
+	}
+	return false
+}
+
+// DNSHealthy reports whether the dns picker may send queries to instance idx:
+// healthy overall and its DNSPort not refusing.
+func DNSHealthy(idx int) bool {
+	return Healthy(idx) && atomic.LoadUint32(&dnsDown[idx]) == 0
+}
+
+// DNSDown reports whether instance idx was pulled from DNS selection.
+func DNSDown(idx int) bool {
+	return valid(idx) && atomic.LoadUint32(&dnsDown[idx]) == 1
+}
+
+// SetDNSHealthy updates the DNSPort flag and returns the previous value.
+func SetDNSHealthy(idx int, ok bool) (was bool) {
+	if !valid(idx) {
+		return false
+	}
+	var v uint32 = 1
+	if ok {
+		v = 0
+		atomic.StoreUint32(&dnsStreak[idx], 0)
+	}
+	return atomic.SwapUint32(&dnsDown[idx], v) == 0
+}
+
+// RecordDNS is RecordDial for queries to tor's DNSPort. It returns true when
+// this refusal just pulled the instance from DNS selection.
+func RecordDNS(idx int, err error) bool {
+	if !valid(idx) {
+		return false
+	}
+	if err == nil {
+		atomic.StoreUint32(&dnsStreak[idx], 0)
+		return false
+	}
+	atomic.AddUint64(&dialFailures[idx], 1)
+	if atomic.AddUint32(&dnsStreak[idx], 1) >= dialFailuresUnhealthy {
+		return SetDNSHealthy(idx, false)
+	}
+	return false
+}
+
+// DialFailures returns the lifetime upstream dial failure count for idx.
+func DialFailures(idx int) uint64 {
+	if !valid(idx) {
+		return 0
+	}
+	return atomic.LoadUint64(&dialFailures[idx])
+}
@@ -0,0 +1,232 @@
+// internal/rules/rules.go — DESTINATION ROUTING RULES (FIRST MATCH WINS)
+//
+// One rule per line:   HOST  PORT  ACTION
+//
+//	HOST    *              any destination
+//	        example.com    exactly that name
+//	        *.example.com  subdomains only
+//	        .example.com   the name and all its subdomains
+//	        *.onion        any onion service
+//	        10.0.0.0/8     IP literals inside a CIDR (v4 or v6)
+//	PORT    *  |  25  |  8000-8999
+//	ACTION  stable | paranoid | reject | default | group:1,3,5-8 (instance IDs)
+//
+// Blank lines and '#' comments are ignored. Destinations matching no rule
+// get Default (normal tier selection).
+package rules
+
+import (
+	"fmt"
+	"net/netip"
+	"strconv"
+	"strings"
+)
+
+type Action uint8
+
+const (
+	Default  Action = iota // no override
+	Stable                 // force the stable tier
+	Paranoid               // force the paranoid tier
+	Reject                 // refuse the request
+	Group                  // restrict to specific instance IDs
+)
+
+func (a Action) String() string {
+	switch a {
+	case Stable:
+		return "stable"
+	case Paranoid:
+		return "paranoid"
+	case Reject:
+		return "reject"
+	case Group:
+		return "group"
+	}
+	return "default"
+}
+
+// Decision is the outcome of matching one destination.
+type Decision struct {
+	Action Action
+	Group  []int // instance IDs, only for Group
+	Line   int   // 1-based rule line that matched, 0 = none
+}
+
+type hostKind uint8
+
+const (
+	hostAny    hostKind = iota
+	hostExact           // example.com
+	hostSubs            // *.example.com
+	hostDomain          // .example.com
+	hostCIDR            // 10.0.0.0/8
+)
+
+type rule struct {
+	kind   hostKind
+	name   string // lower-case, no trailing dot; suffix form starts with '.'
+	prefix netip.Prefix
+	portLo uint16
+	portHi uint16
+	dec    Decision
+}
+
+// Set is an immutable, parsed rule list. A nil *Set matches nothing.
+type Set struct {
+	rules []rule
+}
+
+// Len returns the number of rules.
+func (s *Set) Len() int {
+	if s == nil {
+		return 0
+	}
+	return len(s.rules)
+}
+
+// Parse compiles rule lines; errors name the offending line.
+func Parse(lines []string) (*Set, error) {
+	s := &Set{}
+	for n, raw := range lines {
+		line, _, _ := strings.Cut(raw, "#")
+		f := strings.Fields(line)
+		if len(f) == 0 {
+			continue
+		}
+		if len(f) != 3 {
+			return nil, fmt.Errorf("rules line %d: want HOST PORT ACTION, got %q", n+1, strings.TrimSpace(line))
+		}
+		r, err := parseRule(f[0], f[1], f[2])
+		if err != nil {
+			return nil, fmt.Errorf("rules line %d: %w", n+1, err)
+		}
+		r.dec.Line = n + 1
+		s.rules = append(s.rules, r)
+	}
+	return s, nil
+}
+
+func parseRule(host, port, action string) (rule, error) {
+	var r rule
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+
+	switch {
+	case host == "*":
+		r.kind = hostAny
+	case strings.Contains(host, "/"):
+		p, err := netip.ParsePrefix(host)
+		if err != nil {
+			return r, fmt.Errorf("bad CIDR %q", host)
+		}
+		r.kind, r.prefix = hostCIDR, p.Masked()
+	case strings.HasPrefix(host, "*."):
+		r.kind, r.name = hostSubs, host[1:]
+	case strings.HasPrefix(host, "."):
+		r.kind, r.name = hostDomain, host
+	default:
+		if a, err := netip.ParseAddr(host); err == nil {
+			r.kind, r.prefix = hostCIDR, netip.PrefixFrom(a, a.BitLen())
+		} else {
+			r.kind, r.name = hostExact, host
+		}
+	}
+
+	if port == "*" {
+		r.portLo, r.portHi = 0, 65535
+	} else {
+		lo, hi, isRange := strings.Cut(port, "-")
+		l, err := strconv.ParseUint(lo, 10, 16)
+		if err != nil {
+			return r, fmt.Errorf("bad port %q", port)
+		}
+		h := l
+		if isRange {
+			if h, err = strconv.ParseUint(hi, 10, 16); err != nil || h < l {
+				return r, fmt.Errorf("bad port range %q", port)
+			}
+		}
+		r.portLo, r.portHi = uint16(l), uint16(h)
+	}
+
+	switch {
+	case action == "stable":
+		r.dec.Action = Stable
+	case action == "paranoid":
+		r.dec.Action = Paranoid
+	case action == "reject":
+		r.dec.Action = Reject
+	case action == "default":
+		r.dec.Action = Default
+	case strings.HasPrefix(action, "group:"):
+		ids, err := parseIDs(strings.TrimPrefix(action, "group:"))
+		if err != nil {
+			return r, err
+		}
+		r.dec.Action, r.dec.Group = Group, ids
+	default:
+		return r, fmt.Errorf("unknown action %q", action)
+	}
+	return r, nil
+}
+
+func parseIDs(s string) ([]int, error) {
+	var ids []int
+	for _, part := range strings.Split(s, ",") {
+		lo, hi, isRange := strings.Cut(part, "-")
+		l, err := strconv.Atoi(lo)
+		if err != nil || l < 1 {
+			return nil, fmt.Errorf("bad instance id %q", part)
+		}
+		h := l
+		if isRange {
+			if h, err = strconv.Atoi(hi); err != nil || h < l {
+				return nil, fmt.Errorf("bad instance range %q", part)
+			}
+		}
+		for id := l; id <= h; id++ {
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) == 0 {
+		return nil, fmt.Errorf("empty group")
+	}
+	return ids, nil
+}
+
+// Match returns the decision of the first rule matching host:port.
+// host is a domain name or an IP literal.
+func (s *Set) Match(host string, port uint16) Decision {
+	if s == nil || len(s.rules) == 0 {
+		return Decision{}
+	}
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	addr, addrErr := netip.ParseAddr(host)
+	isIP := addrErr == nil
+
+	for _, r := range s.rules {
+		if port < r.portLo || port > r.portHi {
+			continue
+		}
+		if matchHost(r, host, addr, isIP) {
+			return r.dec
+		}
+	}
+	return Decision{}
+}
+
+func matchHost(r rule, host string, addr netip.Addr, isIP bool) bool {
+	switch r.kind {
+	case hostAny:
+		return true
+	case hostCIDR:
+		return isIP && r.prefix.Contains(addr.Unmap())
+	case hostExact:
+		return !isIP && host == r.name
+	case hostSubs:
+		return !isIP && strings.HasSuffix(host, r.name)
+	case hostDomain:
+		return !isIP && (host == r.name[1:] || strings.HasSuffix(host, r.name))
+	}
+	return false
+}
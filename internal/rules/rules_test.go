@@ -0,0 +1,158 @@
+package rules
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name    string
+		lines   []string
+		wantErr string // substring; "" = must parse
+		wantLen int
+	}{
+		{name: "empty", lines: nil},
+		{name: "blank and comments", lines: []string{"", "   ", "# only a comment", "\t# indented"}},
+		{name: "trailing comment", lines: []string{"example.com 443 stable # pin it"}, wantLen: 1},
+		{name: "every host form", lines: []string{
+			"* * default",
+			"example.com 80 stable",
+			"*.example.com 80 paranoid",
+			".example.org 80 reject",
+			"*.onion * paranoid",
+			"10.0.0.0/8 * reject",
+			"2001:db8::/32 * reject",
+			"192.0.2.1 * reject",
+			"Example.NET. 443 stable",
+		}, wantLen: 9},
+		{name: "port range", lines: []string{"* 8000-8999 stable"}, wantLen: 1},
+		{name: "single-port range", lines: []string{"* 25-25 reject"}, wantLen: 1},
+		{name: "group ids and ranges", lines: []string{"* * group:1,3,5-8"}, wantLen: 1},
+
+		{name: "too few fields", lines: []string{"example.com 443"}, wantErr: "line 1: want HOST PORT ACTION"},
+		{name: "too many fields", lines: []string{"", "example.com 443 stable extra"}, wantErr: "line 2: want HOST PORT ACTION"},
+		{name: "bad cidr", lines: []string{"10.0.0.0/33 * reject"}, wantErr: "bad CIDR"},
+		{name: "non-numeric port", lines: []string{"* https stable"}, wantErr: "bad port"},
+		{name: "port out of range", lines: []string{"* 65536 stable"}, wantErr: "bad port"},
+		{name: "inverted port range", lines: []string{"* 9000-8000 stable"}, wantErr: "bad port range"},
+		{name: "open port range", lines: []string{"* 8000- stable"}, wantErr: "bad port range"},
+		{name: "unknown action", lines: []string{"* * drop"}, wantErr: `unknown action "drop"`},
+		{name: "empty group", lines: []string{"* * group:"}, wantErr: "bad instance id"},
+		{name: "zero instance id", lines: []string{"* * group:0"}, wantErr: "bad instance id"},
+		{name: "inverted group range", lines: []string{"* * group:5-2"}, wantErr: "bad instance range"},
+		{name: "error names its line", lines: []string{"* * stable", "# ok", "* * bogus"}, wantErr: "rules line 3"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := Parse(tt.lines)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err = %v, want it to contain %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if s.Len() != tt.wantLen {
+				t.Errorf("Len() = %d, want %d", s.Len(), tt.wantLen)
+			}
+		})
+	}
+}
+
+func TestParseGroupAndReject(t *testing.T) {
+	s, err := Parse([]string{"* 25 reject", "* * group:2,4-6"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := s.Match("mail.example.com", 25); d.Action != Reject || d.Line != 1 {
+		t.Errorf("port 25: got %v line %d, want reject line 1", d.Action, d.Line)
+	}
+	d := s.Match("example.com", 443)
+	if d.Action != Group || d.Line != 2 || !slices.Equal(d.Group, []int{2, 4, 5, 6}) {
+		t.Errorf("got %v %v line %d, want group [2 4 5 6] line 2", d.Action, d.Group, d.Line)
+	}
+}
+
+func TestMatch(t *testing.T) {
+	s, err := Parse([]string{
+		"* 25 reject",                 // 1: port-only, wins over everything below
+		"pinned.example.com * stable", // 2: exact beats the later suffix rules
+		"*.example.com 443 paranoid",  // 3: subdomains only
+		".example.org * group:1-2",    // 4: apex and subdomains
+		"*.onion * paranoid",          // 5
+		"10.0.0.0/8 * reject",         // 6
+		"2001:db8::/32 * reject",      // 7
+		"* 8000-8999 stable",          // 8
+		"example.com * default",       // 9: the apex rule 3 never covers
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		host string
+		port uint16
+		want Action
+		line int
+	}{
+		// port-only rule matches names and literals alike
+		{"anything.example.com", 25, Reject, 1},
+		{"1.2.3.4", 25, Reject, 1},
+
+		// exact host, case-insensitive, trailing dot ignored
+		{"pinned.example.com", 443, Stable, 2},
+		{"PINNED.Example.COM.", 80, Stable, 2},
+		{"sub.pinned.example.com", 80, Default, 0},
+
+		// *.suffix: subdomains on the rule's port, never the apex
+		{"www.example.com", 443, Paranoid, 3},
+		{"a.b.example.com", 443, Paranoid, 3},
+		{"www.example.com", 80, Default, 0},
+		{"example.com", 443, Default, 9},
+		{"notexample.com", 443, Default, 0},
+
+		// .suffix: apex and subdomains
+		{"example.org", 80, Group, 4},
+		{"deep.sub.example.org", 80, Group, 4},
+		{"badexample.org", 80, Default, 0},
+
+		// onion services
+		{"duckduckgogg42xjoc72x3sjasowoarfbgcmvfimaftt6twagswzczad.onion", 443, Paranoid, 5},
+		{"onion", 443, Default, 0},
+
+		// CIDR rules apply to IP literals only
+		{"10.1.2.3", 443, Reject, 6},
+		{"::ffff:10.1.2.3", 443, Reject, 6},
+		{"11.0.0.1", 443, Default, 0},
+		{"2001:db8::1", 443, Reject, 7},
+		{"10.example.com", 443, Paranoid, 3},
+
+		// port range
+		{"api.local", 8000, Stable, 8},
+		{"api.local", 8999, Stable, 8},
+		{"api.local", 9000, Default, 0},
+	}
+
+	for _, tt := range tests {
+		d := s.Match(tt.host, tt.port)
+		if d.Action != tt.want || d.Line != tt.line {
+			t.Errorf("Match(%q, %d) = %v line %d, want %v line %d",
+				tt.host, tt.port, d.Action, d.Line, tt.want, tt.line)
+		}
+	}
+}
+
+func TestMatchNilSet(t *testing.T) {
+	var s *Set
+	if d := s.Match("example.com", 443); d.Action != Default || d.Line != 0 {
+		t.Errorf("nil set: got %v line %d", d.Action, d.Line)
+	}
+	if s.Len() != 0 {
+		t.Errorf("nil set: Len() = %d", s.Len())
+	}
+}
@@ -7,7 +7,6 @@ import (
 	"net"
 	"net/http"
 	"os"
-	"strconv"
 	"time"
 
 	"torgo/internal/config"
@@ -45,11 +44,8 @@ func ensureNotRoot() error {
 
 func checkSocksHandshake() error {
 	cfg := config.Load()
-	port, err := strconv.Atoi(cfg.SocksPort)
-	if err != nil {
-		return fmt.Errorf("invalid socks port: %v", err)
-	}
-	if err := health.CheckSocks(port); err != nil {
+	network, addr := config.LocalDial(cfg.SocksListeners)
+	if err := health.CheckSocksAddr(network, addr); err != nil {
 		return fmt.Errorf("LIVENESS FAIL: %w", err)
 	}
 	return nil
@@ -60,7 +56,8 @@ func verifyTorConnectivity() error {
 	cfg := config.Load()
 	
 	// Setup SOCKS5 dialer
-	dialer, err := proxy.SOCKS5("tcp", "127.0.0.1:"+cfg.SocksPort, nil, proxy.Direct)
+	network, addr := config.LocalDial(cfg.SocksListeners)
+	dialer, err := proxy.SOCKS5(network, addr, nil, proxy.Direct)
 	if err != nil {
 		return fmt.Errorf("failed to build dialer: %w", err)
 	}
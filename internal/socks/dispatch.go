@@ -0,0 +1,98 @@
+package socks
+
+import (
+	"crypto/rand"
+	"math/big"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"torgo/internal/config"
+	"torgo/internal/rules"
+)
+
+// upstream is a tor stream that has already accepted the client's request.
+type upstream struct {
+	conn  net.Conn
+	idx   int
+	reply []byte // tor's SOCKS5 reply, verbatim
+}
+
+// Close closes the tor side and releases the instance's active-conn slot.
+func (u *upstream) Close() {
+	u.conn.Close()
+	atomic.AddUint32(&instanceConns[u.idx], ^uint32(0))
+}
+
+// dispatch is the frontend-independent half of a proxied request: routing
+// rules, jitter, tier and sticky selection, the failover dial and the replay
+// of req against tor. A non-zero reason is a policy/capacity rejection; err
+// means tor itself could not be talked to. Both SOCKS and HTTP frontends go
+// through here so limits and rotation accounting are shared.
+func dispatch(insts []*config.Instance, cfg *config.Config, req *request, tier tierPref) (*upstream, rejectReason, error) {
+	// Operator routing rules are evaluated first and outrank the client's wishes
+	dec := cfg.Routes.Match(req.host(), req.port)
+	if dec.Action == rules.Reject {
+		return nil, rejectRuleset, nil
+	}
+
+	if cfg.SocksJitterMaxMs > 0 {
+		jMax := cfg.SocksJitterMaxMs
+		if jMax > 5000 {
+			jMax = 5000
+		}
+		rnd, _ := rand.Int(rand.Reader, big.NewInt(int64(jMax+1)))
+		if j := rnd.Int64(); j > 0 {
+			time.Sleep(time.Duration(j) * time.Millisecond)
+		}
+	}
+
+	instCount := len(insts)
+	if instCount == 0 {
+		return nil, rejectNoInstance, nil
+	}
+	if instCount > 32 {
+		instCount = 32
+	}
+
+	// Sticky session: same credentials → same instance while it lasts.
+	// Keyed before the tier prefix is stripped so each tier gets its own binding.
+	key, sticky := sessionKey(req)
+
+	// Tier: fixed by the listener, else "stable:"/"paranoid:" username
+	// prefix, else the ParanoidTrafficPercent coin flip
+	if asked := takeTierPrefix(req); tier == tierAuto {
+		tier = asked
+	}
+	rt := route{prefer: -1}
+	rt.paranoid, rt.strict = resolveTier(tier, cfg.ParanoidTrafficPercent)
+	switch dec.Action {
+	case rules.Stable:
+		rt.paranoid, rt.strict = false, true
+	case rules.Paranoid:
+		rt.paranoid, rt.strict = true, true
+	case rules.Group:
+		rt.grouped, rt.allow, rt.strict = true, groupMask(dec.Group), false
+	}
+	if sticky {
+		rt.prefer = lookupSession(key)
+	}
+
+	tor, idx, reason := dialUpstream(insts, instCount, rt)
+	if tor == nil {
+		return nil, reason, nil
+	}
+	if sticky && idx != rt.prefer {
+		bindSession(key, idx)
+	}
+	up := &upstream{conn: tor, idx: idx}
+
+	_ = tor.SetDeadline(time.Now().Add(handshakeTimeout))
+	reply, err := upstreamHandshake(tor, req)
+	if err != nil {
+		up.Close()
+		return nil, 0, err
+	}
+	up.reply = reply
+	return up, 0, nil
+}
@@ -0,0 +1,287 @@
+package socks
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"torgo/internal/config"
+)
+
+// Hop-by-hop headers (RFC 9110 §7.6.1) plus the proxy-only ones; never
+// forwarded upstream.
+var hopHeaders = []string{
+	"Connection",
+	"Proxy-Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Te",
+	"Trailer",
+	"Upgrade",
+}
+
+// StartHTTP runs the optional HTTP proxy frontend (COMMON_HTTP_PROXY_PORT or
+// COMMON_HTTP_PROXY_LISTEN):
+// CONNECT tunnels and plain absolute-URI http:// requests. Every request is
+// re-issued to tor as a SOCKS5 CONNECT through dispatch, so the instance
+// pool, tiers, caps, sticky sessions and rotation accounting are shared
+// with the SOCKS listener.
+func StartHTTP(ctx context.Context, insts []*config.Instance, cfg *config.Config) {
+	if len(cfg.HTTPListeners) == 0 {
+		return
+	}
+	if n, _ := initPool(ctx, insts, cfg); n == 0 {
+		return
+	}
+
+	// COMMON_HTTP_PROXY_LISTEN, or bind addr:COMMON_HTTP_PROXY_PORT
+	for _, spec := range cfg.HTTPListeners {
+		l, err := spec.Listen()
+		if err != nil {
+			slog.Error("http proxy bind failed", "listener", spec, "err", err)
+			continue
+		}
+		defer l.Close()
+		slog.Info("HTTP proxy active", "listener", spec)
+		go acceptLoop(l, spec.MaxConns, rejectHTTPEarly, func(c net.Conn) {
+			handleHTTP(c, insts, cfg)
+		})
+	}
+
+	<-ctx.Done()
+}
+
+func handleHTTP(client net.Conn, insts []*config.Instance, cfg *config.Config) {
+	// 1. PANIC RECOVERY (Anti-Leak)
+	defer func() {
+		if r := recover(); r != nil {
+			slog.Error("http proxy panic recovered", "err", r)
+		}
+	}()
+
+	defer client.Close()
+	defer atomic.AddUint32(&totalConns, ^uint32(0))
+
+	_ = client.SetDeadline(time.Now().Add(handshakeTimeout))
+
+	// 2. READ ONE REQUEST HEAD (one request per connection, like CONNECT)
+	br := bufio.NewReader(client)
+	hr, err := http.ReadRequest(br)
+	if err != nil {
+		return
+	}
+
+	req, err := httpRequest(hr)
+	if err != nil {
+		writeStatus(client, http.StatusBadRequest)
+		return
+	}
+	defer req.wipe()
+
+	// 3. ROUTE + RE-ISSUE AS A SOCKS5 CONNECT TO TOR
+	up, reason, err := dispatch(insts, cfg, req, tierAuto)
+	if reason != 0 {
+		atomic.AddUint64(&rejectCounts[reason], 1)
+		writeStatus(client, rejectStatuses[reason])
+		return
+	}
+	if err != nil {
+		writeStatus(client, http.StatusBadGateway)
+		return
+	}
+	defer up.Close()
+	tor := up.conn
+
+	if rep := up.reply[1]; rep != repSucceeded {
+		if rep == 0x06 { // TTL expired: tor's circuit/stream timeout
+			writeStatus(client, http.StatusGatewayTimeout)
+		} else {
+			writeStatus(client, http.StatusBadGateway)
+		}
+		return
+	}
+
+	_ = client.SetDeadline(time.Now().Add(connTimeout))
+	_ = tor.SetDeadline(time.Now().Add(connTimeout))
+
+	if hr.Method == http.MethodConnect {
+		if _, err := client.Write([]byte("HTTP/1.1 200 Connection Established\r\n\r\n")); err != nil {
+			return
+		}
+		// Clients may pipeline the TLS ClientHello right behind the CONNECT
+		if n := br.Buffered(); n > 0 {
+			b, _ := br.Peek(n)
+			if _, err := (&meteredWriter{w: tor, ctr: &instanceBytesOut[up.idx]}).Write(b); err != nil {
+				return
+			}
+		}
+		relay(client, tor, up.idx)
+		return
+	}
+
+	// Origin-form request to the destination; the body (if any) is streamed
+	// from br by Write. One request per connection: only this request's
+	// response goes back, with Connection: close, so anything the client
+	// pipelined behind it can never reach this origin.
+	stripHopHeaders(hr.Header)
+	if _, ok := hr.Header["User-Agent"]; !ok {
+		hr.Header["User-Agent"] = []string{""} // keep Go's default UA off the wire
+	}
+	hr.RequestURI = ""
+	hr.Close = true
+	if err := hr.Write(&meteredWriter{w: tor, ctr: &instanceBytesOut[up.idx]}); err != nil {
+		return
+	}
+
+	toClient := &meteredWriter{w: client, ctr: &instanceBytesIn[up.idx]}
+	tr := bufio.NewReader(tor)
+	for {
+		resp, err := http.ReadResponse(tr, hr)
+		if err != nil {
+			writeStatus(client, http.StatusBadGateway)
+			return
+		}
+		informational := resp.StatusCode >= 100 && resp.StatusCode < 200
+		stripHopHeaders(resp.Header)
+		resp.Close = true
+		err = resp.Write(toClient)
+		_ = resp.Body.Close()
+		if err != nil || !informational {
+			return
+		}
+	}
+}
+
+// meteredWriter adds every byte written to an instance byte counter as it
+// goes, so long transfers show up before they finish.
+type meteredWriter struct {
+	w   io.Writer
+	ctr *uint64
+}
+
+func (m *meteredWriter) Write(p []byte) (int, error) {
+	n, err := m.w.Write(p)
+	atomic.AddUint64(m.ctr, uint64(n))
+	return n, err
+}
+
+// httpRequest maps a CONNECT or absolute-URI request onto a SOCKS5 CONNECT
+// request. Proxy-Authorization Basic credentials become the SOCKS
+// username/password, so tier prefixes, sticky sessions and tor's stream
+// isolation behave exactly as on the SOCKS port.
+func httpRequest(hr *http.Request) (*request, error) {
+	var hostport string
+	switch {
+	case hr.Method == http.MethodConnect:
+		hostport = hr.RequestURI
+	case hr.URL.IsAbs() && hr.URL.Scheme == "http":
+		hostport = hr.URL.Host
+		if hr.URL.Port() == "" {
+			hostport = net.JoinHostPort(hr.URL.Hostname(), "80")
+		}
+	default:
+		return nil, errors.New("http: not a proxy request")
+	}
+
+	host, portStr, err := net.SplitHostPort(hostport)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil || port == 0 {
+		return nil, fmt.Errorf("http: bad port %q", portStr)
+	}
+
+	req := &request{cmd: cmdConnect, port: uint16(port)}
+	if ip := net.ParseIP(host); ip != nil {
+		if ip4 := ip.To4(); ip4 != nil {
+			req.atyp, req.addr = atypIPv4, ip4
+		} else {
+			req.atyp, req.addr = atypIPv6, ip.To16()
+		}
+	} else {
+		if host == "" || len(host) > 255 {
+			return nil, errors.New("http: bad host")
+		}
+		req.atyp, req.addr = atypDomain, []byte(host)
+	}
+
+	if user, pass, ok := proxyAuth(hr.Header.Get("Proxy-Authorization")); ok {
+		req.auth, req.user, req.pass = true, user, pass
+	}
+	return req, nil
+}
+
+// proxyAuth decodes a Basic Proxy-Authorization value. Fields longer than
+// SOCKS5 can carry (255 bytes) are refused rather than truncated.
+func proxyAuth(v string) (user, pass []byte, ok bool) {
+	const prefix = "basic "
+	if len(v) < len(prefix) || !strings.EqualFold(v[:len(prefix)], prefix) {
+		return nil, nil, false
+	}
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(v[len(prefix):]))
+	if err != nil {
+		return nil, nil, false
+	}
+	i := strings.IndexByte(string(raw), ':')
+	if i <= 0 || i > 255 || len(raw)-i-1 > 255 {
+		for j := range raw {
+			raw[j] = 0
+		}
+		return nil, nil, false
+	}
+	return raw[:i], raw[i+1:], true
+}
+
+func stripHopHeaders(h http.Header) {
+	// Headers named in Connection are hop-by-hop too
+	for _, v := range h["Connection"] {
+		for _, f := range strings.Split(v, ",") {
+			if f = strings.TrimSpace(f); f != "" {
+				h.Del(f)
+			}
+		}
+	}
+	for _, k := range hopHeaders {
+		h.Del(k)
+	}
+}
+
+func writeStatus(c net.Conn, code int) {
+	_, _ = fmt.Fprintf(c, "HTTP/1.1 %d %s\r\nContent-Length: 0\r\nConnection: close\r\n\r\n",
+		code, http.StatusText(code))
+}
+
+// rejectHTTPEarly is rejectEarly for the HTTP frontend: read the request
+// head under a short deadline, then answer with a status instead of a reset.
+func rejectHTTPEarly(c net.Conn, reason rejectReason) {
+	atomic.AddUint64(&rejectCounts[reason], 1)
+
+	if atomic.AddInt32(&rejectsInFlight, 1) > maxRejectsInFlight {
+		atomic.AddInt32(&rejectsInFlight, -1)
+		_ = c.Close()
+		return
+	}
+
+	go func() {
+		defer atomic.AddInt32(&rejectsInFlight, -1)
+		defer c.Close()
+
+		_ = c.SetDeadline(time.Now().Add(rejectTimeout))
+		if _, err := http.ReadRequest(bufio.NewReader(c)); err != nil {
+			return
+		}
+		writeStatus(c, rejectStatuses[reason])
+	}()
+}
@@ -0,0 +1,42 @@
+package socks
+
+import (
+	"fmt"
+	"log/slog"
+	"sync/atomic"
+)
+
+// Operator actions (admin API). Instances are addressed by Instance.ID.
+
+func slotFor(id int) (int, error) {
+	idx, ok := slotByID[id]
+	if !ok || idx >= poolCount {
+		return -1, fmt.Errorf("unknown instance %d", id)
+	}
+	return idx, nil
+}
+
+// Rotate forces a rotation of instance id now, in the instance's configured
+// mode: NEWNYM in the background, or drain + restart once idle. Returns the
+// mode used ("newnym" or "restart").
+func Rotate(id int) (string, error) {
+	idx, err := slotFor(id)
+	if err != nil {
+		return "", err
+	}
+	inst := poolInsts[idx]
+
+	if !instHardRotate[idx] {
+		if !atomic.CompareAndSwapUint32(&instanceRotating[idx], 0, 1) {
+			return "", fmt.Errorf("instance %d: rotation already in flight", id)
+		}
+		slog.Info("forced rotation requested", "id", id, "mode", "newnym")
+		go softRotate(idx, inst)
+		return "newnym", nil
+	}
+	if !atomic.CompareAndSwapUint32(&instanceDraining[idx], 0, 1) {
+		return "", fmt.Errorf("instance %d: already draining for rotation", id)
+	}
+	slog.Info("forced rotation requested", "id", id, "mode", "restart")
+	return "restart", nil
+}
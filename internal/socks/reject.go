@@ -0,0 +1,103 @@
+package socks
+
+import (
+	"net"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// rejectReason is why torgo itself turned a client away (never a destination).
+type rejectReason int
+
+const (
+	rejectGlobalCap   rejectReason = iota + 1 // TORGO_MAX_TOTAL_CONNS reached
+	rejectInstanceCap                         // every usable instance at its per-instance cap
+	rejectNoInstance                          // nothing ready/healthy in either tier
+	rejectDialFailed                          // instances picked but tor refused every dial
+	rejectRuleset                             // destination matched a "reject" routing rule
+	rejectListenerCap                         // the listener's own ";max=N" cap reached
+	numRejectReasons
+)
+
+var rejectNames = [numRejectReasons]string{
+	rejectGlobalCap:   "global_cap",
+	rejectInstanceCap: "instance_cap",
+	rejectNoInstance:  "no_instance",
+	rejectDialFailed:  "dial_failed",
+	rejectRuleset:     "ruleset",
+	rejectListenerCap: "listener_cap",
+}
+
+// SOCKS5 reply code sent for each reason. Capacity limits are transient
+// (general failure, retry later); 0x02 is kept for ruleset denials only.
+var rejectReplies = [numRejectReasons]byte{
+	rejectGlobalCap:   repGeneralFailure,
+	rejectInstanceCap: repGeneralFailure,
+	rejectNoInstance:  repNetUnreachable,
+	rejectDialFailed:  repNetUnreachable,
+	rejectRuleset:     repNotAllowed,
+	rejectListenerCap: repGeneralFailure,
+}
+
+// HTTP status sent for each reason by the HTTP proxy frontend
+var rejectStatuses = [numRejectReasons]int{
+	rejectGlobalCap:   http.StatusServiceUnavailable,
+	rejectInstanceCap: http.StatusServiceUnavailable,
+	rejectNoInstance:  http.StatusBadGateway,
+	rejectDialFailed:  http.StatusBadGateway,
+	rejectRuleset:     http.StatusForbidden,
+	rejectListenerCap: http.StatusServiceUnavailable,
+}
+
+var rejectCounts [numRejectReasons]uint64
+
+const (
+	// Rejecting politely still costs a goroutine; past this many in flight
+	// (i.e. under a flood) we fall back to a bare close.
+	maxRejectsInFlight = 64
+	rejectTimeout      = 5 * time.Second
+)
+
+var rejectsInFlight int32
+
+// RejectCounts returns a snapshot of reject counters keyed by reason.
+func RejectCounts() map[string]uint64 {
+	out := make(map[string]uint64, len(rejectNames))
+	for r := rejectGlobalCap; r < numRejectReasons; r++ {
+		out[rejectNames[r]] = atomic.LoadUint64(&rejectCounts[r])
+	}
+	return out
+}
+
+// reject counts the reason and sends the matching reply on a connection
+// whose handshake has already been read.
+func reject(c net.Conn, req *request, reason rejectReason) {
+	atomic.AddUint64(&rejectCounts[reason], 1)
+	_ = writeReply(c, req, rejectReplies[reason])
+}
+
+// rejectEarly handles clients turned away before a handler slot was granted:
+// run the handshake under a short deadline so the client gets a real reply.
+func rejectEarly(c net.Conn, reason rejectReason) {
+	atomic.AddUint64(&rejectCounts[reason], 1)
+
+	if atomic.AddInt32(&rejectsInFlight, 1) > maxRejectsInFlight {
+		atomic.AddInt32(&rejectsInFlight, -1)
+		_ = c.Close()
+		return
+	}
+
+	go func() {
+		defer atomic.AddInt32(&rejectsInFlight, -1)
+		defer c.Close()
+
+		_ = c.SetDeadline(time.Now().Add(rejectTimeout))
+		req, _, err := readRequest(c)
+		if err != nil {
+			return
+		}
+		req.wipe()
+		_ = writeReply(c, req, rejectReplies[reason])
+	}()
+}
@@ -9,10 +9,12 @@ import (
 	"math"
 	"math/big"
 	"net"
+	"sync"
 	"sync/atomic"
 	"time"
 
 	"torgo/internal/config"
+	"torgo/internal/registry"
 )
 
 // per-instance state (supports up to 32 instances)
@@ -21,26 +23,117 @@ var (
 	instanceConns       [32]uint32 // active conns
 	instanceTotal       [32]uint64 // total conns since last restart
 	instanceDraining    [32]uint32 // 1 = draining, 0 = normal
-	instanceLastRestart [32]int64  // unix ts
+	instanceRotating    [32]uint32 // 1 = NEWNYM in flight
+	instanceLastRestart [32]int64  // unix ts of last rotation (soft or hard)
+
+	// lifetime counters (metrics); never reset by rotation
+	instanceSoftRotations [32]uint64
+	instanceHardRotations [32]uint64
+	instanceBytesIn       [32]uint64 // tor → client
+	instanceBytesOut      [32]uint64 // client → tor
 
 	// per-instance tuning (tier aware)
 	instMaxConns    [32]int32
 	instRotateConns [32]uint64
 	instRotateSecs  [32]int64
 	instTier        [32]uint8 // 0 = stable, 1 = paranoid
+	instHardRotate  [32]bool  // true = drain + restart tor, false = SIGNAL NEWNYM
+)
+
+var (
+	maxTotalConns    int32 = 512
+	connTimeout            = 15 * time.Minute
+	dialTimeout            = 5 * time.Second
+	handshakeTimeout       = 30 * time.Second
 )
 
 var (
-	maxTotalConns int32 = 512
-	connTimeout         = 15 * time.Minute
+	poolOnce   sync.Once
+	poolCount  int
+	poolStable int
+	poolInsts  []*config.Instance // as passed to initPool (operator actions)
 )
 
+// Upper bound on instances tried per client before giving up.
+const maxDialAttempts = 4
+
 func Start(ctx context.Context, insts []*config.Instance, cfg *config.Config) {
-	instCount := len(insts)
+	instCount, stableCount := initPool(ctx, insts, cfg)
 	if instCount == 0 {
 		slog.Error("no instances configured")
 		return
 	}
+
+	// One or more main listeners (COMMON_SOCKS_LISTEN), each optionally capped
+	var addrs []string
+	for _, spec := range cfg.SocksListeners {
+		l, err := spec.Listen()
+		if err != nil {
+			slog.Error("socks bind failed", "listener", spec, "err", err)
+			continue
+		}
+		defer l.Close()
+		addrs = append(addrs, spec.String())
+		go serve(l, insts, cfg, tierAuto, spec.MaxConns)
+	}
+	if len(addrs) == 0 {
+		return
+	}
+
+	slog.Info("SOCKS proxy active",
+		"listeners", addrs,
+		"maxTotalConns", maxTotalConns,
+		"stableCount", stableCount,
+		"paranoidCount", instCount-stableCount,
+		"paranoidTrafficPercent", cfg.ParanoidTrafficPercent,
+		"socksJitterMaxMs", cfg.SocksJitterMaxMs,
+		"stableHardRotate", cfg.StableHardRotate,
+		"paranoidHardRotate", cfg.ParanoidHardRotate,
+		"stickyTTL", stickyTTL,
+		"routeRules", cfg.Routes.Len(),
+	)
+
+	// Optional per-tier listeners: everything arriving there is pinned to the tier
+	for _, tl := range []struct {
+		specs []config.Listener
+		tier  tierPref
+	}{
+		{cfg.SocksStableListeners, tierStable},
+		{cfg.SocksParanoidListeners, tierParanoid},
+	} {
+		for _, spec := range tl.specs {
+			tln, err := spec.Listen()
+			if err != nil {
+				slog.Error("socks tier bind failed", "tier", tl.tier, "listener", spec, "err", err)
+				continue
+			}
+			defer tln.Close()
+			slog.Info("SOCKS tier listener active", "listener", spec, "tier", tl.tier)
+			go serve(tln, insts, cfg, tl.tier, spec.MaxConns)
+		}
+	}
+
+	<-ctx.Done()
+}
+
+// initPool sets up the shared per-instance tables once, whichever frontend
+// (SOCKS, HTTP) starts first. Returns the usable instance and stable counts.
+func initPool(ctx context.Context, insts []*config.Instance, cfg *config.Config) (int, int) {
+	poolOnce.Do(func() {
+		poolInsts = insts
+		poolCount, poolStable = setupPool(insts, cfg)
+		if poolCount > 0 {
+			go manageRotations(ctx, insts)
+		}
+	})
+	return poolCount, poolStable
+}
+
+func setupPool(insts []*config.Instance, cfg *config.Config) (int, int) {
+	instCount := len(insts)
+	if instCount == 0 {
+		return 0, 0
+	}
 	if instCount > 32 {
 		instCount = 32
 	}
@@ -48,6 +141,8 @@ func Start(ctx context.Context, insts []*config.Instance, cfg *config.Config) {
 	if cfg.MaxTotalConns > 0 {
 		maxTotalConns = int32(cfg.MaxTotalConns)
 	}
+	stickyTTL = time.Duration(cfg.StickyTTLSeconds) * time.Second
+	stickyUsePass = cfg.StickyKey == "userpass"
 
 	stableCount := cfg.StableInstances
 	if stableCount > instCount {
@@ -66,6 +161,7 @@ func Start(ctx context.Context, insts []*config.Instance, cfg *config.Config) {
 			instMaxConns[idx] = int32(cfg.ParanoidMaxConnsPerInstance)
 			instRotateConns[idx] = uint64(cfg.ParanoidRotateConns)
 			instRotateSecs[idx] = int64(cfg.ParanoidRotateSeconds)
+			instHardRotate[idx] = cfg.ParanoidHardRotate
 		} else {
 			instTier[idx] = 0
 			stableMax := cfg.StableMaxConnsPerInstance
@@ -75,44 +171,56 @@ func Start(ctx context.Context, insts []*config.Instance, cfg *config.Config) {
 			instMaxConns[idx] = int32(stableMax)
 			instRotateConns[idx] = uint64(cfg.StableRotateConns)
 			instRotateSecs[idx] = int64(cfg.StableRotateSeconds)
+			instHardRotate[idx] = cfg.StableHardRotate
 		}
-		atomic.StoreInt64(&instanceLastRestart[idx], now)
-	}
+		slotByID[insts[idx].ID] = idx
 
-	addr := net.JoinHostPort(cfg.SocksBindAddr, cfg.SocksPort)
-	l, err := net.Listen("tcp", addr)
-	if err != nil {
-		slog.Error("socks bind failed", "err", err)
-		return
+		// No control port → NEWNYM impossible, only restarts remain
+		if insts[idx].ControlPath() == "" {
+			instHardRotate[idx] = true
+		}
+		atomic.StoreInt64(&instanceLastRestart[idx], now)
 	}
-	defer l.Close()
-
-	slog.Info("SOCKS proxy active",
-		"addr", l.Addr(),
-		"maxTotalConns", maxTotalConns,
-		"stableCount", stableCount,
-		"paranoidCount", instCount-stableCount,
-		"paranoidTrafficPercent", cfg.ParanoidTrafficPercent,
-		"socksJitterMaxMs", cfg.SocksJitterMaxMs,
-	)
+	return instCount, stableCount
+}
 
-	go manageRotations(ctx, insts)
+// serve accepts clients on l; tier is the listener's fixed tier (tierAuto
+// for the main port, where the username prefix or the coin flip decides).
+// maxConns > 0 caps this listener on top of the global cap.
+func serve(l net.Listener, insts []*config.Instance, cfg *config.Config, tier tierPref, maxConns int) {
+	acceptLoop(l, maxConns, rejectEarly, func(c net.Conn) {
+		handleSOCKS(c, insts, cfg, tier)
+	})
+}
 
+// acceptLoop is the accept side shared by every frontend: the service-wide
+// cap, then the listener's own ";max=N" cap, each turned away through the
+// frontend's reject. handle owns the connection and its totalConns slot.
+func acceptLoop(l net.Listener, maxConns int, reject func(net.Conn, rejectReason), handle func(net.Conn)) {
+	var active int32
 	for {
 		c, err := l.Accept()
 		if err != nil {
 			return
 		}
 		if atomic.LoadUint32(&totalConns) >= uint32(maxTotalConns) {
-			_ = c.Close()
+			reject(c, rejectGlobalCap)
+			continue
+		}
+		if maxConns > 0 && atomic.LoadInt32(&active) >= int32(maxConns) {
+			reject(c, rejectListenerCap)
 			continue
 		}
 		atomic.AddUint32(&totalConns, 1)
-		go handleSOCKS(c, insts, cfg)
+		atomic.AddInt32(&active, 1)
+		go func() {
+			defer atomic.AddInt32(&active, -1)
+			handle(c)
+		}()
 	}
 }
 
-func handleSOCKS(client net.Conn, insts []*config.Instance, cfg *config.Config) {
+func handleSOCKS(client net.Conn, insts []*config.Instance, cfg *config.Config, tier tierPref) {
 	// 1. PANIC RECOVERY (Anti-Leak)
 	// If this goroutine crashes, capture it silently instead of dumping secret data to logs.
 	defer func() {
@@ -124,67 +232,116 @@ func handleSOCKS(client net.Conn, insts []*config.Instance, cfg *config.Config)
 	defer client.Close()
 	defer atomic.AddUint32(&totalConns, ^uint32(0))
 
-	_ = client.SetDeadline(time.Now().Add(connTimeout))
+	// Short deadline for the handshake (anti-slowloris), full one once piping
+	_ = client.SetDeadline(time.Now().Add(handshakeTimeout))
 
-	if cfg.SocksJitterMaxMs > 0 {
-		jMax := cfg.SocksJitterMaxMs
-		if jMax > 5000 {
-			jMax = 5000
-		}
-		rnd, _ := rand.Int(rand.Reader, big.NewInt(int64(jMax+1)))
-		if j := rnd.Int64(); j > 0 {
-			time.Sleep(time.Duration(j) * time.Millisecond)
+	// 2. TERMINATE SOCKS5 / SOCKS4a (greeting, auth, request)
+	req, rep, err := readRequest(client)
+	if err != nil {
+		if rep != 0 {
+			_ = writeReply(client, req, rep)
 		}
+		return
 	}
+	defer req.wipe()
 
-	instCount := len(insts)
-	if instCount == 0 {
+	// 3. ROUTE + RE-ISSUE THE REQUEST TO TOR, RELAY ITS REPLY
+	up, reason, err := dispatch(insts, cfg, req, tier)
+	if reason != 0 {
+		reject(client, req, reason)
 		return
 	}
-	if instCount > 32 {
-		instCount = 32
-	}
-
-	useParanoid := false
-	if cfg.ParanoidTrafficPercent > 0 {
-		rnd, _ := rand.Int(rand.Reader, big.NewInt(100))
-		if rnd.Int64() < int64(cfg.ParanoidTrafficPercent) {
-			useParanoid = true
-		}
+	if err != nil {
+		_ = writeReply(client, req, repGeneralFailure)
+		return
 	}
+	defer up.Close()
+	tor, reply := up.conn, up.reply
 
-	chosenIdx := pickInstance(instCount, useParanoid)
-	if chosenIdx < 0 {
-		chosenIdx = pickInstance(instCount, !useParanoid)
-	}
-	if chosenIdx < 0 {
+	if _, err := client.Write(clientReply(req, reply)); err != nil {
 		return
 	}
 
-	if atomic.AddUint32(&instanceConns[chosenIdx], 1) > uint32(instMaxConns[chosenIdx]) {
-		atomic.AddUint32(&instanceConns[chosenIdx], ^uint32(0))
+	// RESOLVE/RESOLVE_PTR end with the reply; failed CONNECTs too
+	if req.cmd != cmdConnect || reply[1] != repSucceeded {
 		return
 	}
-	defer atomic.AddUint32(&instanceConns[chosenIdx], ^uint32(0))
 
-	inst := insts[chosenIdx]
-	atomic.AddUint64(&instanceTotal[chosenIdx], 1)
+	_ = client.SetDeadline(time.Now().Add(connTimeout))
+	_ = tor.SetDeadline(time.Now().Add(connTimeout))
 
-	// FIX: Use standard string formatting to avoid null bytes
-	addr := fmt.Sprintf("127.0.0.1:%d", inst.SocksPort)
+	relay(client, tor, up.idx)
+}
 
-	tor, err := net.Dial("tcp", addr)
-	if err != nil {
-		return
+// dialUpstream picks an instance and dials its SOCKS port, failing over to
+// the next best instance in the preferred tier, then (unless the tier was
+// chosen explicitly) the other tier, for at most maxDialAttempts tries.
+// A usable sticky slot is tried first. On success the instance's active-conn
+// slot is held and the caller must release it; on failure reason says why.
+func dialUpstream(insts []*config.Instance, instCount int, rt route) (net.Conn, int, rejectReason) {
+	var tried uint32 // bitmask of slots already attempted
+	reason := rejectNoInstance
+
+	// Slots outside a routing-rule group are never candidates
+	var excluded uint32
+	if rt.grouped {
+		excluded = ^rt.allow
 	}
-	defer tor.Close()
-	_ = tor.SetDeadline(time.Now().Add(connTimeout))
 
-	go boundedCopy(tor, client)
-	boundedCopy(client, tor)
+	for attempt := 0; attempt < maxDialAttempts; attempt++ {
+		idx, capped := -1, false
+		if attempt == 0 && rt.prefer >= 0 && rt.prefer < instCount && usable(insts, rt.prefer) &&
+			excluded&(1<<uint(rt.prefer)) == 0 &&
+			(!rt.strict || (instTier[rt.prefer] == 1) == rt.paranoid) &&
+			atomic.LoadUint32(&instanceConns[rt.prefer]) < uint32(instMaxConns[rt.prefer]) {
+			idx = rt.prefer
+		} else {
+			idx, capped = pickInstance(insts, instCount, rt.paranoid, tried|excluded)
+		}
+		if idx < 0 && !rt.strict {
+			var otherCapped bool
+			idx, otherCapped = pickInstance(insts, instCount, !rt.paranoid, tried|excluded)
+			capped = capped || otherCapped
+		}
+		if idx < 0 {
+			if capped {
+				reason = rejectInstanceCap
+			}
+			return nil, -1, reason
+		}
+		tried |= 1 << uint(idx)
+
+		if atomic.AddUint32(&instanceConns[idx], 1) > uint32(instMaxConns[idx]) {
+			atomic.AddUint32(&instanceConns[idx], ^uint32(0))
+			reason = rejectInstanceCap
+			continue
+		}
+
+		inst := insts[idx]
+
+		// FIX: Use standard string formatting to avoid null bytes
+		addr := fmt.Sprintf("127.0.0.1:%d", inst.SocksPort)
+
+		tor, err := net.DialTimeout("tcp", addr, dialTimeout)
+		if registry.RecordDial(idx, err) {
+			slog.Warn("tor instance refusing connections — pulled from pool", "id", inst.ID)
+		}
+		if err != nil {
+			atomic.AddUint32(&instanceConns[idx], ^uint32(0))
+			reason = rejectDialFailed
+			continue
+		}
+
+		atomic.AddUint64(&instanceTotal[idx], 1)
+		return tor, idx, 0
+	}
+	return nil, -1, reason
 }
 
-func pickInstance(instCount int, wantParanoid bool) int {
+// pickInstance returns the least-loaded usable slot in the wanted tier, or -1.
+// capped reports that at least one otherwise usable slot was full, so a -1
+// means "over capacity" rather than "nothing alive".
+func pickInstance(insts []*config.Instance, instCount int, wantParanoid bool, skip uint32) (idx int, capped bool) {
 	randIdx, _ := rand.Int(rand.Reader, big.NewInt(int64(instCount)))
 	start := int(randIdx.Int64())
 
@@ -194,6 +351,10 @@ func pickInstance(instCount int, wantParanoid bool) int {
 	for off := 0; off < instCount; off++ {
 		idx := (start + off) % instCount
 
+		if skip&(1<<uint(idx)) != 0 {
+			continue
+		}
+
 		tier := instTier[idx]
 		if wantParanoid && tier != 1 {
 			continue
@@ -202,13 +363,14 @@ func pickInstance(instCount int, wantParanoid bool) int {
 			continue
 		}
 
-		if atomic.LoadUint32(&instanceDraining[idx]) == 1 {
+		if !usable(insts, idx) {
 			continue
 		}
 
 		load := atomic.LoadUint32(&instanceConns[idx])
 		limit := uint32(instMaxConns[idx])
 		if load >= limit {
+			capped = true
 			continue
 		}
 		if load < bestLoad {
@@ -216,7 +378,23 @@ func pickInstance(instCount int, wantParanoid bool) int {
 			bestIdx = idx
 		}
 	}
-	return bestIdx
+	return bestIdx, capped
+}
+
+// usable reports whether slot idx may take new connections at all.
+func usable(insts []*config.Instance, idx int) bool {
+	if atomic.LoadUint32(&instanceDraining[idx]) == 1 {
+		return false
+	}
+
+	// Dead (supervisor saw it exit) or still bootstrapping
+	if !insts[idx].Ready() {
+		return false
+	}
+
+	// Marked unhealthy by health (unresponsive, crashed, breaker open)
+	// or drained by the operator
+	return registry.Healthy(idx) && !registry.Drained(idx)
 }
 
 func manageRotations(ctx context.Context, insts []*config.Instance) {
@@ -228,6 +406,7 @@ func manageRotations(ctx context.Context, insts []*config.Instance) {
 		case <-ctx.Done():
 			return
 		case <-ticker.C:
+			sweepSessions()
 			now := time.Now().Unix()
 			for idx, inst := range insts {
 				if idx >= len(insts) || idx >= 32 {
@@ -248,6 +427,13 @@ func manageRotations(ctx context.Context, insts []*config.Instance) {
 				if draining == 0 {
 					if (rotConns > 0 && total >= rotConns) ||
 						(rotSecs > 0 && last != 0 && now-last >= rotSecs) {
+						if !instHardRotate[idx] {
+							// Soft rotation: no drain needed, live streams keep their circuits
+							if atomic.CompareAndSwapUint32(&instanceRotating[idx], 0, 1) {
+								go softRotate(idx, inst)
+							}
+							continue
+						}
 						if atomic.CompareAndSwapUint32(&instanceDraining[idx], 0, 1) {
 							slog.Info("marking tor instance for rotation",
 								"id", inst.ID,
@@ -263,9 +449,11 @@ func manageRotations(ctx context.Context, insts []*config.Instance) {
 							continue
 						}
 						atomic.StoreUint64(&instanceTotal[idx], 0)
+						atomic.AddUint64(&instanceGen[idx], 1)
+						atomic.AddUint64(&instanceHardRotations[idx], 1)
 						atomic.StoreUint32(&instanceDraining[idx], 0)
 						atomic.StoreInt64(&instanceLastRestart[idx], now)
-						slog.Info("rotation complete", "id", inst.ID)
+						slog.Info("rotation complete", "id", inst.ID, "mode", "restart")
 					}
 				}
 			}
@@ -273,7 +461,34 @@ func manageRotations(ctx context.Context, insts []*config.Instance) {
 	}
 }
 
-func boundedCopy(dst net.Conn, src net.Conn) (written int64, err error) {
+// softRotate swaps circuits via SIGNAL NEWNYM. If the control port fails the
+// instance falls back to the drain + restart path so rotation still happens.
+func softRotate(idx int, inst *config.Instance) {
+	defer atomic.StoreUint32(&instanceRotating[idx], 0)
+
+	slog.Info("rotating tor circuits (newnym)", "id", inst.ID, "tier", instTier[idx])
+	if err := inst.NewIdentity(); err != nil {
+		slog.Error("newnym rotation failed — falling back to restart", "id", inst.ID, "err", err)
+		atomic.StoreUint32(&instanceDraining[idx], 1)
+		return
+	}
+	atomic.StoreUint64(&instanceTotal[idx], 0)
+	atomic.AddUint64(&instanceGen[idx], 1)
+	atomic.AddUint64(&instanceSoftRotations[idx], 1)
+	atomic.StoreInt64(&instanceLastRestart[idx], time.Now().Unix())
+	slog.Info("rotation complete", "id", inst.ID, "mode", "newnym")
+}
+
+// relay pipes both directions until either side is done and books the
+// bytes against instance idx as they flow (counts only, never content or
+// destination), so long-lived tunnels show up in every scrape.
+func relay(client, tor net.Conn, idx int) {
+	go func() { _, _ = boundedCopy(tor, client, &instanceBytesOut[idx]) }()
+	_, _ = boundedCopy(client, tor, &instanceBytesIn[idx])
+}
+
+// boundedCopy copies src to dst, adding each chunk written to ctr.
+func boundedCopy(dst net.Conn, src net.Conn, ctr *uint64) (written int64, err error) {
 	// 2. SECURE MEMORY ALLOCATION
 	// Allocate 64KB buffer for data transfer
 	buf := make([]byte, 64<<10)
@@ -292,6 +507,7 @@ func boundedCopy(dst net.Conn, src net.Conn) (written int64, err error) {
 		if nr > 0 {
 			nw, ew := dst.Write(buf[:nr])
 			written += int64(nw)
+			atomic.AddUint64(ctr, uint64(nw))
 			if ew != nil {
 				err = ew
 				break
@@ -309,4 +525,4 @@ func boundedCopy(dst net.Conn, src net.Conn) (written int64, err error) {
 		}
 	}
 	return
-}
\ No newline at end of file
+}
@@ -0,0 +1,400 @@
+package socks
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+)
+
+// SOCKS5 (RFC 1928) + username/password (RFC 1929) + tor's RESOLVE extensions,
+// plus SOCKS4/4a on the client side (tor is always spoken to in SOCKS5)
+const (
+	socks4Version = 0x04
+	socks5Version = 0x05
+	authVersion   = 0x01
+
+	authNone         = 0x00
+	authUserPass     = 0x02
+	authNoAcceptable = 0xFF
+
+	cmdConnect    = 0x01
+	cmdResolve    = 0xF0 // tor extension: hostname → address
+	cmdResolvePTR = 0xF1 // tor extension: address → hostname
+
+	atypIPv4   = 0x01
+	atypDomain = 0x03
+	atypIPv6   = 0x04
+
+	repSucceeded        = 0x00
+	repGeneralFailure   = 0x01
+	repNotAllowed       = 0x02
+	repNetUnreachable   = 0x03
+	repCmdNotSupported  = 0x07
+	repAddrNotSupported = 0x08
+
+	rep4Granted  = 0x5A
+	rep4Rejected = 0x5B
+
+	maxSocks4Field = 255 // USERID / HOSTNAME, NUL-terminated
+)
+
+var errBadVersion = errors.New("socks: not a SOCKS4/5 client")
+
+// request is a parsed client request, ready to be re-issued to tor.
+// Credentials are forwarded so tor's IsolateSOCKSAuth keeps working.
+type request struct {
+	ver  byte // client's protocol: socks4Version or socks5Version (0 = SOCKS5)
+	cmd  byte
+	atyp byte
+	addr []byte // DST.ADDR as sent (domain without its length byte)
+	port uint16
+
+	auth bool // client negotiated username/password
+	user []byte
+	pass []byte
+}
+
+// host returns the destination as a string (domain or IP literal).
+func (r *request) host() string {
+	switch r.atyp {
+	case atypIPv4, atypIPv6:
+		return net.IP(r.addr).String()
+	}
+	return string(r.addr)
+}
+
+// wipe zeroes credentials and destination (Anti-Forensics).
+func (r *request) wipe() {
+	for _, b := range [][]byte{r.addr, r.user, r.pass} {
+		for i := range b {
+			b[i] = 0
+		}
+	}
+}
+
+// readRequest runs the server side of the handshake: greeting, optional
+// username/password sub-negotiation, then the request itself. SOCKS4/4a
+// requests are accepted too and mapped onto the same request. Reads are
+// exact-length so optimistic data sent after the request stays in the socket.
+// On a protocol-level rejection the returned byte is the reply code to send
+// and the request (otherwise empty) carries the client's version for
+// writeReply.
+func readRequest(c net.Conn) (*request, byte, error) {
+	var ver [1]byte
+	if _, err := io.ReadFull(c, ver[:]); err != nil {
+		return nil, 0, err
+	}
+	switch ver[0] {
+	case socks5Version:
+	case socks4Version:
+		return readSocks4(c)
+	default:
+		return nil, 0, errBadVersion
+	}
+
+	var n [1]byte
+	if _, err := io.ReadFull(c, n[:]); err != nil {
+		return nil, 0, err
+	}
+	methods := make([]byte, n[0])
+	if _, err := io.ReadFull(c, methods); err != nil {
+		return nil, 0, err
+	}
+
+	// Prefer username/password when offered: tor isolates streams per credential
+	method := byte(authNoAcceptable)
+	for _, m := range methods {
+		if m == authUserPass {
+			method = authUserPass
+			break
+		}
+		if m == authNone {
+			method = authNone
+		}
+	}
+	if _, err := c.Write([]byte{socks5Version, method}); err != nil {
+		return nil, 0, err
+	}
+	if method == authNoAcceptable {
+		return nil, 0, errors.New("socks: no acceptable auth method")
+	}
+
+	req := &request{ver: socks5Version}
+	if method == authUserPass {
+		if err := readUserPass(c, req); err != nil {
+			return nil, 0, err
+		}
+		if _, err := c.Write([]byte{authVersion, 0x00}); err != nil {
+			req.wipe()
+			return nil, 0, err
+		}
+	}
+
+	var head [4]byte
+	if _, err := io.ReadFull(c, head[:]); err != nil {
+		req.wipe()
+		return nil, 0, err
+	}
+	if head[0] != socks5Version {
+		return req.reject(repGeneralFailure, errBadVersion)
+	}
+	req.cmd, req.atyp = head[1], head[3]
+
+	switch req.cmd {
+	case cmdConnect, cmdResolve, cmdResolvePTR:
+	default:
+		return req.reject(repCmdNotSupported, fmt.Errorf("socks: unsupported command 0x%02x", req.cmd))
+	}
+
+	var err error
+	switch req.atyp {
+	case atypIPv4:
+		req.addr, err = readN(c, net.IPv4len)
+	case atypIPv6:
+		req.addr, err = readN(c, net.IPv6len)
+	case atypDomain:
+		var l [1]byte
+		if _, err = io.ReadFull(c, l[:]); err == nil {
+			if l[0] == 0 {
+				err = errors.New("socks: empty domain")
+			} else {
+				req.addr, err = readN(c, int(l[0]))
+			}
+		}
+	default:
+		return req.reject(repAddrNotSupported, fmt.Errorf("socks: bad address type 0x%02x", req.atyp))
+	}
+	if err != nil {
+		return req.reject(repGeneralFailure, err)
+	}
+
+	port, err := readN(c, 2)
+	if err != nil {
+		return req.reject(repGeneralFailure, err)
+	}
+	req.port = binary.BigEndian.Uint16(port)
+	return req, 0, nil
+}
+
+// reject wipes what was read so far and leaves only the version, so the
+// caller can still answer in the client's protocol.
+func (r *request) reject(rep byte, err error) (*request, byte, error) {
+	r.wipe()
+	return &request{ver: r.ver}, rep, err
+}
+
+// readSocks4 parses the rest of a SOCKS4/4a request (the version byte has
+// been read): CD DSTPORT DSTIP USERID NUL [HOSTNAME NUL]. The USERID becomes
+// the username tor isolates on; 4a's 0.0.0.x address announces a hostname.
+// tor's RESOLVE extension is accepted, BIND is not.
+func readSocks4(c net.Conn) (*request, byte, error) {
+	head, err := readN(c, 7)
+	if err != nil {
+		return nil, 0, err
+	}
+	req := &request{ver: socks4Version, cmd: head[0], port: binary.BigEndian.Uint16(head[1:3])}
+	ip := head[3:7]
+
+	user, err := readNul(c)
+	if err != nil {
+		return req.reject(rep4Rejected, err)
+	}
+	if len(user) > 0 {
+		req.auth, req.user, req.pass = true, user, []byte{}
+	}
+
+	if ip[0] == 0 && ip[1] == 0 && ip[2] == 0 && ip[3] != 0 {
+		host, err := readNul(c)
+		if err != nil {
+			return req.reject(rep4Rejected, err)
+		}
+		if len(host) == 0 {
+			return req.reject(rep4Rejected, errors.New("socks: empty domain"))
+		}
+		req.atyp, req.addr = atypDomain, host
+	} else {
+		req.atyp, req.addr = atypIPv4, ip
+	}
+
+	switch req.cmd {
+	case cmdConnect, cmdResolve:
+	default:
+		return req.reject(rep4Rejected, fmt.Errorf("socks: unsupported SOCKS4 command 0x%02x", req.cmd))
+	}
+	return req, 0, nil
+}
+
+// readNul reads a NUL-terminated SOCKS4 field one byte at a time, so nothing
+// past the request is consumed.
+func readNul(c net.Conn) ([]byte, error) {
+	var b []byte
+	var one [1]byte
+	for {
+		if _, err := io.ReadFull(c, one[:]); err != nil {
+			return nil, err
+		}
+		if one[0] == 0 {
+			return b, nil
+		}
+		if len(b) == maxSocks4Field {
+			return nil, errors.New("socks: SOCKS4 field too long")
+		}
+		b = append(b, one[0])
+	}
+}
+
+func readUserPass(c net.Conn, req *request) error {
+	var v [2]byte
+	if _, err := io.ReadFull(c, v[:]); err != nil {
+		return err
+	}
+	if v[0] != authVersion || v[1] == 0 {
+		// RFC 1929: a non-zero status, then the server closes
+		_, _ = c.Write([]byte{authVersion, 0x01})
+		return errors.New("socks: bad auth sub-negotiation")
+	}
+	user, err := readN(c, int(v[1]))
+	if err != nil {
+		return err
+	}
+	var pl [1]byte
+	if _, err := io.ReadFull(c, pl[:]); err != nil {
+		return err
+	}
+	pass, err := readN(c, int(pl[0]))
+	if err != nil {
+		return err
+	}
+	req.auth, req.user, req.pass = true, user, pass
+	return nil
+}
+
+// upstreamHandshake replays the client's request against tor's SocksPort
+// and returns tor's reply verbatim (to be relayed to the client).
+func upstreamHandshake(tor net.Conn, req *request) ([]byte, error) {
+	method := byte(authNone)
+	if req.auth {
+		method = authUserPass
+	}
+	if _, err := tor.Write([]byte{socks5Version, 0x01, method}); err != nil {
+		return nil, err
+	}
+	sel, err := readN(tor, 2)
+	if err != nil {
+		return nil, err
+	}
+	if sel[0] != socks5Version || sel[1] != method {
+		return nil, fmt.Errorf("socks: tor refused auth method 0x%02x", method)
+	}
+
+	if req.auth {
+		b := make([]byte, 0, 3+len(req.user)+len(req.pass))
+		b = append(b, authVersion, byte(len(req.user)))
+		b = append(b, req.user...)
+		b = append(b, byte(len(req.pass)))
+		b = append(b, req.pass...)
+		_, err := tor.Write(b)
+		for i := range b {
+			b[i] = 0
+		}
+		if err != nil {
+			return nil, err
+		}
+		st, err := readN(tor, 2)
+		if err != nil {
+			return nil, err
+		}
+		if st[1] != 0x00 {
+			return nil, errors.New("socks: tor rejected credentials")
+		}
+	}
+
+	b := make([]byte, 0, 7+len(req.addr))
+	b = append(b, socks5Version, req.cmd, 0x00, req.atyp)
+	if req.atyp == atypDomain {
+		b = append(b, byte(len(req.addr)))
+	}
+	b = append(b, req.addr...)
+	b = binary.BigEndian.AppendUint16(b, req.port)
+	if _, err := tor.Write(b); err != nil {
+		return nil, err
+	}
+
+	return readReply(tor)
+}
+
+// readReply reads one complete SOCKS5 reply (VER REP RSV ATYP BND.ADDR BND.PORT).
+func readReply(c net.Conn) ([]byte, error) {
+	head, err := readN(c, 4)
+	if err != nil {
+		return nil, err
+	}
+	if head[0] != socks5Version {
+		return nil, errBadVersion
+	}
+
+	var n int
+	switch head[3] {
+	case atypIPv4:
+		n = net.IPv4len
+	case atypIPv6:
+		n = net.IPv6len
+	case atypDomain:
+		l, err := readN(c, 1)
+		if err != nil {
+			return nil, err
+		}
+		head = append(head, l[0])
+		n = int(l[0])
+	default:
+		return nil, fmt.Errorf("socks: bad reply address type 0x%02x", head[3])
+	}
+
+	rest, err := readN(c, n+2)
+	if err != nil {
+		return nil, err
+	}
+	return append(head, rest...), nil
+}
+
+// writeReply sends a reply with an all-zero IPv4 bind address, in the
+// client's protocol. req may be nil (SOCKS5).
+func writeReply(c net.Conn, req *request, rep byte) error {
+	if req != nil && req.ver == socks4Version {
+		code := byte(rep4Rejected)
+		if rep == repSucceeded {
+			code = rep4Granted
+		}
+		_, err := c.Write([]byte{0x00, code, 0, 0, 0, 0, 0, 0})
+		return err
+	}
+	_, err := c.Write([]byte{socks5Version, rep, 0x00, atypIPv4, 0, 0, 0, 0, 0, 0})
+	return err
+}
+
+// clientReply converts tor's SOCKS5 reply for the client: verbatim for
+// SOCKS5, else VN CD DSTPORT DSTIP (the resolved IPv4 for RESOLVE).
+func clientReply(req *request, reply []byte) []byte {
+	if req.ver != socks4Version {
+		return reply
+	}
+	out := []byte{0x00, rep4Rejected, 0, 0, 0, 0, 0, 0}
+	if reply[1] == repSucceeded {
+		out[1] = rep4Granted
+	}
+	if reply[3] == atypIPv4 && len(reply) == 10 {
+		copy(out[2:4], reply[8:10])
+		copy(out[4:8], reply[4:8])
+	}
+	return out
+}
+
+func readN(r io.Reader, n int) ([]byte, error) {
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
@@ -0,0 +1,366 @@
+package socks
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"testing"
+)
+
+// serverSide runs readRequest against a client that sends in, and returns
+// everything the server wrote back.
+func serverSide(t *testing.T, in []byte) (*request, byte, error, []byte) {
+	t.Helper()
+	srv, cli := net.Pipe()
+	defer cli.Close()
+
+	go func() { _, _ = cli.Write(in) }()
+	out := make(chan []byte, 1)
+	go func() {
+		b, _ := io.ReadAll(cli)
+		out <- b
+	}()
+
+	req, rep, err := readRequest(srv)
+	_ = srv.Close()
+	return req, rep, err, <-out
+}
+
+func cat(parts ...[]byte) []byte { return bytes.Join(parts, nil) }
+
+func TestReadRequest(t *testing.T) {
+	noAuth := []byte{0x05, 0x01, 0x00}
+	userPass := []byte{0x05, 0x02, 0x00, 0x02}
+	creds := []byte{0x01, 0x03, 'b', 'o', 'b', 0x02, 'p', 'w'}
+
+	tests := []struct {
+		name    string
+		in      []byte
+		wantOut []byte
+		wantRep byte
+		wantErr bool
+		check   func(*testing.T, *request)
+	}{
+		{
+			name:    "connect ipv4 no auth",
+			in:      cat(noAuth, []byte{0x05, 0x01, 0x00, 0x01, 10, 0, 0, 1, 0x01, 0xBB}),
+			wantOut: []byte{0x05, 0x00},
+			check: func(t *testing.T, r *request) {
+				if r.cmd != cmdConnect || r.host() != "10.0.0.1" || r.port != 443 || r.auth {
+					t.Errorf("got cmd=%x host=%s port=%d auth=%v", r.cmd, r.host(), r.port, r.auth)
+				}
+			},
+		},
+		{
+			name:    "connect domain with user/pass",
+			in:      cat(userPass, creds, []byte{0x05, 0x01, 0x00, 0x03, 11}, []byte("example.com"), []byte{0x00, 0x50}),
+			wantOut: []byte{0x05, 0x02, 0x01, 0x00},
+			check: func(t *testing.T, r *request) {
+				if r.host() != "example.com" || r.port != 80 || !r.auth ||
+					string(r.user) != "bob" || string(r.pass) != "pw" {
+					t.Errorf("got host=%s port=%d user=%q pass=%q", r.host(), r.port, r.user, r.pass)
+				}
+			},
+		},
+		{
+			name:    "connect ipv6",
+			in:      cat(noAuth, []byte{0x05, 0x01, 0x00, 0x04}, net.ParseIP("2001:db8::1"), []byte{0x00, 0x16}),
+			wantOut: []byte{0x05, 0x00},
+			check: func(t *testing.T, r *request) {
+				if r.host() != "2001:db8::1" || r.port != 22 {
+					t.Errorf("got host=%s port=%d", r.host(), r.port)
+				}
+			},
+		},
+		{
+			name:    "resolve",
+			in:      cat(noAuth, []byte{0x05, 0xF0, 0x00, 0x03, 7}, []byte("tor.org"), []byte{0, 0}),
+			wantOut: []byte{0x05, 0x00},
+			check: func(t *testing.T, r *request) {
+				if r.cmd != cmdResolve || r.host() != "tor.org" {
+					t.Errorf("got cmd=%x host=%s", r.cmd, r.host())
+				}
+			},
+		},
+		{
+			name:    "resolve_ptr",
+			in:      cat(noAuth, []byte{0x05, 0xF1, 0x00, 0x01, 1, 1, 1, 1, 0, 0}),
+			wantOut: []byte{0x05, 0x00},
+			check: func(t *testing.T, r *request) {
+				if r.cmd != cmdResolvePTR || r.host() != "1.1.1.1" {
+					t.Errorf("got cmd=%x host=%s", r.cmd, r.host())
+				}
+			},
+		},
+		{
+			name:    "no acceptable method",
+			in:      []byte{0x05, 0x01, 0x01},
+			wantOut: []byte{0x05, 0xFF},
+			wantErr: true,
+		},
+		{
+			name:    "bad auth sub-negotiation gets failure status",
+			in:      cat(userPass, []byte{0x05, 0x03}),
+			wantOut: []byte{0x05, 0x02, 0x01, 0x01},
+			wantErr: true,
+		},
+		{
+			name:    "bind not supported",
+			in:      cat(noAuth, []byte{0x05, 0x02, 0x00, 0x01, 1, 2, 3, 4, 0, 80}),
+			wantOut: []byte{0x05, 0x00},
+			wantRep: repCmdNotSupported,
+			wantErr: true,
+		},
+		{
+			name:    "bad address type",
+			in:      cat(noAuth, []byte{0x05, 0x01, 0x00, 0x09}),
+			wantOut: []byte{0x05, 0x00},
+			wantRep: repAddrNotSupported,
+			wantErr: true,
+		},
+		{
+			name:    "empty domain",
+			in:      cat(noAuth, []byte{0x05, 0x01, 0x00, 0x03, 0x00}),
+			wantOut: []byte{0x05, 0x00},
+			wantRep: repGeneralFailure,
+			wantErr: true,
+		},
+		{
+			name:    "unknown version",
+			in:      []byte{0x06, 0x01, 0x00},
+			wantErr: true,
+		},
+		{
+			name: "socks4 connect",
+			in:   cat([]byte{0x04, 0x01, 0x00, 0x50, 93, 184, 216, 34}, []byte("alice\x00")),
+			check: func(t *testing.T, r *request) {
+				if r.ver != socks4Version || r.cmd != cmdConnect || r.host() != "93.184.216.34" ||
+					r.port != 80 || !r.auth || string(r.user) != "alice" || len(r.pass) != 0 {
+					t.Errorf("got ver=%d host=%s port=%d user=%q", r.ver, r.host(), r.port, r.user)
+				}
+			},
+		},
+		{
+			name: "socks4a domain, no userid",
+			in:   cat([]byte{0x04, 0x01, 0x01, 0xBB, 0, 0, 0, 1, 0x00}, []byte("example.onion\x00")),
+			check: func(t *testing.T, r *request) {
+				if r.atyp != atypDomain || r.host() != "example.onion" || r.port != 443 || r.auth {
+					t.Errorf("got atyp=%x host=%s port=%d auth=%v", r.atyp, r.host(), r.port, r.auth)
+				}
+			},
+		},
+		{
+			name: "socks4a resolve",
+			in:   cat([]byte{0x04, 0xF0, 0, 0, 0, 0, 0, 1, 0x00}, []byte("tor.org\x00")),
+			check: func(t *testing.T, r *request) {
+				if r.cmd != cmdResolve || r.host() != "tor.org" {
+					t.Errorf("got cmd=%x host=%s", r.cmd, r.host())
+				}
+			},
+		},
+		{
+			name:    "socks4 bind rejected",
+			in:      []byte{0x04, 0x02, 0x00, 0x50, 1, 2, 3, 4, 0x00},
+			wantRep: rep4Rejected,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, rep, err, out := serverSide(t, tt.in)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if rep != tt.wantRep {
+				t.Errorf("rep = %#x, want %#x", rep, tt.wantRep)
+			}
+			if !bytes.Equal(out, tt.wantOut) {
+				t.Errorf("server wrote %x, want %x", out, tt.wantOut)
+			}
+			if tt.check != nil && req != nil {
+				tt.check(t, req)
+			}
+		})
+	}
+}
+
+// Optimistic data after the request must stay in the socket for the relay.
+func TestReadRequestLeavesPayload(t *testing.T) {
+	srv, cli := net.Pipe()
+	defer srv.Close()
+	defer cli.Close()
+
+	go func() {
+		_, _ = cli.Write([]byte{0x05, 0x01, 0x00})
+		_, _ = io.ReadFull(cli, make([]byte, 2))
+		_, _ = cli.Write([]byte{0x05, 0x01, 0x00, 0x01, 1, 2, 3, 4, 0, 80, 'G', 'E', 'T'})
+	}()
+
+	if _, _, err := readRequest(srv); err != nil {
+		t.Fatal(err)
+	}
+	rest := make([]byte, 3)
+	if _, err := io.ReadFull(srv, rest); err != nil || string(rest) != "GET" {
+		t.Fatalf("payload = %q, %v", rest, err)
+	}
+}
+
+func TestWriteReply(t *testing.T) {
+	tests := []struct {
+		name string
+		req  *request
+		rep  byte
+		want []byte
+	}{
+		{"socks5 nil request", nil, repNotAllowed, []byte{0x05, 0x02, 0x00, 0x01, 0, 0, 0, 0, 0, 0}},
+		{"socks5", &request{ver: socks5Version}, repGeneralFailure, []byte{0x05, 0x01, 0x00, 0x01, 0, 0, 0, 0, 0, 0}},
+		{"socks4 reject", &request{ver: socks4Version}, repNotAllowed, []byte{0x00, rep4Rejected, 0, 0, 0, 0, 0, 0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, cli := net.Pipe()
+			defer cli.Close()
+			go func() {
+				_ = writeReply(srv, tt.req, tt.rep)
+				_ = srv.Close()
+			}()
+			got, _ := io.ReadAll(cli)
+			if !bytes.Equal(got, tt.want) {
+				t.Errorf("got %x, want %x", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClientReply(t *testing.T) {
+	ok := []byte{0x05, 0x00, 0x00, 0x01, 93, 184, 216, 34, 0x01, 0xBB}
+	fail := []byte{0x05, 0x04, 0x00, 0x01, 0, 0, 0, 0, 0, 0}
+
+	if got := clientReply(&request{ver: socks5Version}, ok); !bytes.Equal(got, ok) {
+		t.Errorf("socks5: got %x, want verbatim", got)
+	}
+	if got, want := clientReply(&request{ver: socks4Version}, ok),
+		[]byte{0x00, rep4Granted, 0x01, 0xBB, 93, 184, 216, 34}; !bytes.Equal(got, want) {
+		t.Errorf("socks4 granted: got %x, want %x", got, want)
+	}
+	if got := clientReply(&request{ver: socks4Version}, fail); got[1] != rep4Rejected {
+		t.Errorf("socks4 failed: got %x", got)
+	}
+}
+
+// fakeTor plays tor's side of the SOCKS5 handshake: it writes script, hangs
+// up, and records everything upstreamHandshake sent.
+func fakeTor(t *testing.T, req *request, script []byte) ([]byte, []byte, error) {
+	t.Helper()
+	cli, tor := net.Pipe()
+
+	sent := make(chan []byte, 1)
+	go func() {
+		b, _ := io.ReadAll(tor)
+		sent <- b
+	}()
+	go func() {
+		// Once the script is consumed tor hangs up, like a real peer would
+		_, _ = tor.Write(script)
+		_ = tor.Close()
+	}()
+
+	reply, err := upstreamHandshake(cli, req)
+	_ = cli.Close()
+	return reply, <-sent, err
+}
+
+func TestUpstreamHandshake(t *testing.T) {
+	okReply := []byte{0x05, 0x00, 0x00, 0x01, 0, 0, 0, 0, 0, 0}
+
+	tests := []struct {
+		name      string
+		req       *request
+		script    []byte
+		wantSent  []byte
+		wantReply []byte
+		wantErr   bool
+	}{
+		{
+			name:      "connect domain no auth",
+			req:       &request{cmd: cmdConnect, atyp: atypDomain, addr: []byte("a.com"), port: 443},
+			script:    cat([]byte{0x05, 0x00}, okReply),
+			wantSent:  cat([]byte{0x05, 0x01, 0x00}, []byte{0x05, 0x01, 0x00, 0x03, 5}, []byte("a.com"), []byte{0x01, 0xBB}),
+			wantReply: okReply,
+		},
+		{
+			name:   "connect ipv4 with credentials",
+			req:    &request{cmd: cmdConnect, atyp: atypIPv4, addr: []byte{1, 2, 3, 4}, port: 80, auth: true, user: []byte("u"), pass: []byte("pw")},
+			script: cat([]byte{0x05, 0x02}, []byte{0x01, 0x00}, okReply),
+			wantSent: cat([]byte{0x05, 0x01, 0x02}, []byte{0x01, 1, 'u', 2, 'p', 'w'},
+				[]byte{0x05, 0x01, 0x00, 0x01, 1, 2, 3, 4, 0, 80}),
+			wantReply: okReply,
+		},
+		{
+			name:      "socks4 userid, empty password",
+			req:       &request{cmd: cmdConnect, atyp: atypIPv4, addr: []byte{1, 2, 3, 4}, port: 80, auth: true, user: []byte("u"), pass: []byte{}},
+			script:    cat([]byte{0x05, 0x02}, []byte{0x01, 0x00}, okReply),
+			wantSent:  cat([]byte{0x05, 0x01, 0x02}, []byte{0x01, 1, 'u', 0}, []byte{0x05, 0x01, 0x00, 0x01, 1, 2, 3, 4, 0, 80}),
+			wantReply: okReply,
+		},
+		{
+			name:      "resolve_ptr reply with domain",
+			req:       &request{cmd: cmdResolvePTR, atyp: atypIPv4, addr: []byte{1, 1, 1, 1}},
+			script:    cat([]byte{0x05, 0x00}, []byte{0x05, 0x00, 0x00, 0x03, 3}, []byte("one"), []byte{0, 0}),
+			wantSent:  cat([]byte{0x05, 0x01, 0x00}, []byte{0x05, 0xF1, 0x00, 0x01, 1, 1, 1, 1, 0, 0}),
+			wantReply: cat([]byte{0x05, 0x00, 0x00, 0x03, 3}, []byte("one"), []byte{0, 0}),
+		},
+		{
+			name:      "reply with ipv6 bind address",
+			req:       &request{cmd: cmdConnect, atyp: atypDomain, addr: []byte("a"), port: 1},
+			script:    cat([]byte{0x05, 0x00}, []byte{0x05, 0x04, 0x00, 0x04}, make([]byte, 16), []byte{0, 0}),
+			wantSent:  cat([]byte{0x05, 0x01, 0x00}, []byte{0x05, 0x01, 0x00, 0x03, 1, 'a', 0, 1}),
+			wantReply: cat([]byte{0x05, 0x04, 0x00, 0x04}, make([]byte, 16), []byte{0, 0}),
+		},
+		{
+			name:     "tor picks another method",
+			req:      &request{cmd: cmdConnect, atyp: atypDomain, addr: []byte("a"), port: 1},
+			script:   []byte{0x05, 0xFF},
+			wantSent: []byte{0x05, 0x01, 0x00},
+			wantErr:  true,
+		},
+		{
+			name:     "tor rejects credentials",
+			req:      &request{cmd: cmdConnect, atyp: atypDomain, addr: []byte("a"), port: 1, auth: true, user: []byte("u"), pass: []byte("p")},
+			script:   []byte{0x05, 0x02, 0x01, 0x01},
+			wantSent: cat([]byte{0x05, 0x01, 0x02}, []byte{0x01, 1, 'u', 1, 'p'}),
+			wantErr:  true,
+		},
+		{
+			name:     "bad reply address type",
+			req:      &request{cmd: cmdConnect, atyp: atypDomain, addr: []byte("a"), port: 1},
+			script:   []byte{0x05, 0x00, 0x05, 0x00, 0x00, 0x09},
+			wantSent: cat([]byte{0x05, 0x01, 0x00}, []byte{0x05, 0x01, 0x00, 0x03, 1, 'a', 0, 1}),
+			wantErr:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reply, sent, err := fakeTor(t, tt.req, tt.script)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !bytes.Equal(sent, tt.wantSent) {
+				t.Errorf("sent %x, want %x", sent, tt.wantSent)
+			}
+			if !bytes.Equal(reply, tt.wantReply) {
+				t.Errorf("reply %x, want %x", reply, tt.wantReply)
+			}
+		})
+	}
+}
+
+func TestUpstreamHandshakeEOF(t *testing.T) {
+	req := &request{cmd: cmdConnect, atyp: atypDomain, addr: []byte("a"), port: 1}
+	if _, _, err := fakeTor(t, req, []byte{0x05}); !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		t.Fatalf("err = %v, want EOF", err)
+	}
+}
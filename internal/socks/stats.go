@@ -0,0 +1,55 @@
+package socks
+
+import (
+	"sync/atomic"
+
+	"torgo/internal/config"
+)
+
+// InstanceStats is a point-in-time view of one pool slot. Counts only:
+// nothing here identifies a client or a destination.
+type InstanceStats struct {
+	ID            int
+	Tier          string // "stable" or "paranoid"
+	Active        uint32 // open proxied connections
+	SinceRotation uint64 // connections since the last rotation
+	Draining      bool   // draining for a hard rotation
+	LastRotation  int64  // unix ts of the last rotation (or pool start)
+	SoftRotations uint64 // NEWNYM rotations
+	HardRotations uint64 // drain + restart rotations
+	BytesIn       uint64 // tor → client
+	BytesOut      uint64 // client → tor
+}
+
+// Snapshot returns per-instance stats for the pool built from insts.
+func Snapshot(insts []*config.Instance) []InstanceStats {
+	n := len(insts)
+	if n > 32 {
+		n = 32
+	}
+	out := make([]InstanceStats, 0, n)
+	for idx := 0; idx < n; idx++ {
+		tier := tierStable
+		if instTier[idx] == 1 {
+			tier = tierParanoid
+		}
+		out = append(out, InstanceStats{
+			ID:            insts[idx].ID,
+			Tier:          tier.String(),
+			Active:        atomic.LoadUint32(&instanceConns[idx]),
+			SinceRotation: atomic.LoadUint64(&instanceTotal[idx]),
+			Draining:      atomic.LoadUint32(&instanceDraining[idx]) == 1,
+			LastRotation:  atomic.LoadInt64(&instanceLastRestart[idx]),
+			SoftRotations: atomic.LoadUint64(&instanceSoftRotations[idx]),
+			HardRotations: atomic.LoadUint64(&instanceHardRotations[idx]),
+			BytesIn:       atomic.LoadUint64(&instanceBytesIn[idx]),
+			BytesOut:      atomic.LoadUint64(&instanceBytesOut[idx]),
+		})
+	}
+	return out
+}
+
+// ActiveConns returns the number of proxied connections across all frontends.
+func ActiveConns() uint32 {
+	return atomic.LoadUint32(&totalConns)
+}
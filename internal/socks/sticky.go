@@ -0,0 +1,108 @@
+package socks
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Sticky sessions: connections presenting the same SOCKS credentials keep
+// landing on the same instance (same exit) until it rotates or the session
+// sits idle for the TTL; every connection that uses it extends it.
+// Keys are salted hashes, so raw usernames/passwords never sit in the table.
+
+const maxStickySessions = 4096
+
+type stickySession struct {
+	idx     int
+	gen     uint64 // instanceGen at bind time; a rotation invalidates it
+	proc    uint32 // Instance.Generation at bind time; any restart invalidates it
+	expires int64  // unix ts, pushed out on every use
+}
+
+var (
+	stickyTTL     time.Duration // 0 = disabled
+	stickyUsePass bool          // key on username:password instead of username
+
+	stickyMu   sync.Mutex
+	sticky     = make(map[[32]byte]stickySession)
+	stickySalt [32]byte
+
+	instanceGen [32]uint64 // bumped on every rotation (soft or hard)
+)
+
+func init() {
+	_, _ = rand.Read(stickySalt[:])
+}
+
+// sessionKey derives the sticky key for req; ok=false when stickiness does
+// not apply (disabled, or the client sent no credentials).
+func sessionKey(req *request) (key [32]byte, ok bool) {
+	if stickyTTL <= 0 || !req.auth {
+		return key, false
+	}
+	h := sha256.New()
+	h.Write(stickySalt[:])
+	h.Write(req.user)
+	if stickyUsePass {
+		h.Write([]byte{0})
+		h.Write(req.pass)
+	}
+	copy(key[:], h.Sum(nil))
+	return key, true
+}
+
+// lookupSession returns the bound instance slot, or -1. A hit refreshes the
+// session's TTL so an active session is never moved mid-use.
+func lookupSession(key [32]byte) int {
+	stickyMu.Lock()
+	defer stickyMu.Unlock()
+
+	s, ok := sticky[key]
+	if !ok {
+		return -1
+	}
+	if time.Now().Unix() >= s.expires || s.stale() {
+		delete(sticky, key)
+		return -1
+	}
+	s.expires = time.Now().Add(stickyTTL).Unix()
+	sticky[key] = s
+	return s.idx
+}
+
+func bindSession(key [32]byte, idx int) {
+	stickyMu.Lock()
+	defer stickyMu.Unlock()
+
+	if _, exists := sticky[key]; !exists && len(sticky) >= maxStickySessions {
+		return // table full: this client just isn't sticky until sweep frees room
+	}
+	sticky[key] = stickySession{
+		idx:     idx,
+		gen:     atomic.LoadUint64(&instanceGen[idx]),
+		proc:    poolInsts[idx].Generation(),
+		expires: time.Now().Add(stickyTTL).Unix(),
+	}
+}
+
+// stale reports whether the instance has rotated or its tor process has been
+// replaced (health restart, admin rotate) since the session was bound.
+func (s stickySession) stale() bool {
+	return atomic.LoadUint64(&instanceGen[s.idx]) != s.gen ||
+		poolInsts[s.idx].Generation() != s.proc
+}
+
+// sweepSessions drops expired, rotated-away and restarted-away sessions.
+func sweepSessions() {
+	now := time.Now().Unix()
+	stickyMu.Lock()
+	defer stickyMu.Unlock()
+	for k, s := range sticky {
+		if now >= s.expires || s.stale() {
+			delete(sticky, k)
+		}
+	}
+}
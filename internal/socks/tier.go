@@ -0,0 +1,100 @@
+package socks
+
+import (
+	"bytes"
+	"crypto/rand"
+	"math/big"
+)
+
+// tierPref is how a request asked to be routed.
+type tierPref uint8
+
+const (
+	tierAuto     tierPref = iota // no preference: ParanoidTrafficPercent coin flip
+	tierStable                   // explicit: stable tier only
+	tierParanoid                 // explicit: paranoid tier only
+)
+
+func (t tierPref) String() string {
+	switch t {
+	case tierStable:
+		return "stable"
+	case tierParanoid:
+		return "paranoid"
+	}
+	return "auto"
+}
+
+var (
+	prefixStable   = []byte("stable:")
+	prefixParanoid = []byte("paranoid:")
+)
+
+// route is the per-request selection policy handed to dialUpstream.
+type route struct {
+	paranoid bool // tier to try first
+	strict   bool // explicit tier: never fail over to the other one
+	prefer   int  // sticky slot to try first, -1 = none
+
+	grouped bool   // routing rule restricted the request to an instance group
+	allow   uint32 // slot mask of that group
+}
+
+// slotByID maps Instance.ID → slot index, for group: routing rules.
+var slotByID = map[int]int{}
+
+func groupMask(ids []int) uint32 {
+	var m uint32
+	for _, id := range ids {
+		if idx, ok := slotByID[id]; ok {
+			m |= 1 << uint(idx)
+		}
+	}
+	return m
+}
+
+// takeTierPrefix strips a "stable:" / "paranoid:" username prefix and
+// returns the tier it asked for. The stripped name is what tor sees; a bare
+// prefix leaves the tier word itself so password isolation still applies.
+func takeTierPrefix(req *request) tierPref {
+	if !req.auth {
+		return tierAuto
+	}
+	var t tierPref
+	var p []byte
+	switch {
+	case bytes.HasPrefix(req.user, prefixStable):
+		t, p = tierStable, prefixStable
+	case bytes.HasPrefix(req.user, prefixParanoid):
+		t, p = tierParanoid, prefixParanoid
+	default:
+		return tierAuto
+	}
+
+	rest := req.user[len(p):]
+	if len(rest) == 0 {
+		rest = p[:len(p)-1]
+	}
+	user := append([]byte(nil), rest...)
+	for i := range req.user {
+		req.user[i] = 0
+	}
+	req.user = user
+	return t
+}
+
+// resolveTier turns a preference into a concrete route tier. Only explicit
+// choices are strict; auto keeps the old random split with cross-tier failover.
+func resolveTier(t tierPref, paranoidPercent int) (paranoid, strict bool) {
+	switch t {
+	case tierStable:
+		return false, true
+	case tierParanoid:
+		return true, true
+	}
+	if paranoidPercent > 0 {
+		rnd, _ := rand.Int(rand.Reader, big.NewInt(100))
+		return rnd.Int64() < int64(paranoidPercent), false
+	}
+	return false, false
+}
@@ -0,0 +1,166 @@
+package socks
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"log/slog"
+	"net"
+	"net/netip"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/sys/unix"
+
+	"torgo/internal/config"
+)
+
+// IP6T_SO_ORIGINAL_DST from linux/netfilter_ipv6/ip6_tables.h (not in x/sys)
+const ip6tSoOriginalDst = 80
+
+// StartTransparent runs the optional TransPort-style listener
+// (COMMON_TRANS_PROXY_PORT) for traffic redirected with iptables REDIRECT.
+// The original destination is read with SO_ORIGINAL_DST and re-issued to
+// tor as a SOCKS5 CONNECT through dispatch, so balancing, caps, routing
+// rules and rotation accounting match the SOCKS listener.
+//
+// Redirected clients carry no credentials, so they always land in the
+// ParanoidTrafficPercent coin flip and are never sticky. Destinations are
+// IP literals: resolve through torgo's DNS, not the container's resolver.
+func StartTransparent(ctx context.Context, insts []*config.Instance, cfg *config.Config) {
+	if len(cfg.TransListeners) == 0 {
+		return
+	}
+	if n, _ := initPool(ctx, insts, cfg); n == 0 {
+		return
+	}
+
+	// COMMON_TRANS_PROXY_LISTEN, or bind addr:COMMON_TRANS_PROXY_PORT
+	for _, spec := range cfg.TransListeners {
+		l, err := spec.Listen()
+		if err != nil {
+			slog.Error("transparent proxy bind failed", "listener", spec, "err", err)
+			continue
+		}
+		defer l.Close()
+		slog.Info("transparent proxy active", "listener", spec)
+		go acceptLoop(l, spec.MaxConns, rejectClose, func(c net.Conn) {
+			handleTransparent(c, insts, cfg)
+		})
+	}
+
+	<-ctx.Done()
+}
+
+// rejectClose is the transparent frontend's reject: there is no handshake
+// to answer on, so over a cap the client just sees a close.
+func rejectClose(c net.Conn, reason rejectReason) {
+	atomic.AddUint64(&rejectCounts[reason], 1)
+	_ = c.Close()
+}
+
+func handleTransparent(client net.Conn, insts []*config.Instance, cfg *config.Config) {
+	// 1. PANIC RECOVERY (Anti-Leak)
+	defer func() {
+		if r := recover(); r != nil {
+			slog.Error("transparent proxy panic recovered", "err", r)
+		}
+	}()
+
+	defer client.Close()
+	defer atomic.AddUint32(&totalConns, ^uint32(0))
+
+	// 2. RECOVER THE PRE-NAT DESTINATION
+	dst, err := originalDst(client)
+	if err != nil {
+		return
+	}
+	// A direct (non-redirected) connection reports the listener itself;
+	// proxying that would loop straight back here
+	if local, ok := client.LocalAddr().(*net.TCPAddr); ok &&
+		local.AddrPort().Addr().Unmap() == dst.Addr().Unmap() && local.AddrPort().Port() == dst.Port() {
+		return
+	}
+
+	req := &request{cmd: cmdConnect, port: dst.Port()}
+	if a := dst.Addr().Unmap(); a.Is4() {
+		b := a.As4()
+		req.atyp, req.addr = atypIPv4, b[:]
+	} else {
+		b := a.As16()
+		req.atyp, req.addr = atypIPv6, b[:]
+	}
+	defer req.wipe()
+
+	// 3. ROUTE + CONNECT THROUGH TOR
+	up, reason, err := dispatch(insts, cfg, req, tierAuto)
+	if reason != 0 {
+		atomic.AddUint64(&rejectCounts[reason], 1)
+		return
+	}
+	if err != nil {
+		return
+	}
+	defer up.Close()
+	tor := up.conn
+
+	if up.reply[1] != repSucceeded {
+		return
+	}
+
+	_ = client.SetDeadline(time.Now().Add(connTimeout))
+	_ = tor.SetDeadline(time.Now().Add(connTimeout))
+
+	relay(client, tor, up.idx)
+}
+
+// originalDst returns the destination the client dialled before netfilter
+// rewrote it (SO_ORIGINAL_DST / IP6T_SO_ORIGINAL_DST).
+func originalDst(c net.Conn) (netip.AddrPort, error) {
+	tc, ok := c.(*net.TCPConn)
+	if !ok {
+		return netip.AddrPort{}, errors.New("socks: transparent conn is not TCP")
+	}
+	local, _ := tc.LocalAddr().(*net.TCPAddr)
+	v4 := local == nil || local.IP.To4() != nil
+
+	raw, err := tc.SyscallConn()
+	if err != nil {
+		return netip.AddrPort{}, err
+	}
+
+	var dst netip.AddrPort
+	var serr error
+	err = raw.Control(func(fd uintptr) {
+		if v4 {
+			// struct sockaddr_in fits in the 16-byte Multiaddr field
+			mreq, err := unix.GetsockoptIPv6Mreq(int(fd), unix.SOL_IP, unix.SO_ORIGINAL_DST)
+			if err != nil {
+				serr = err
+				return
+			}
+			sa := mreq.Multiaddr
+			dst = netip.AddrPortFrom(
+				netip.AddrFrom4([4]byte{sa[4], sa[5], sa[6], sa[7]}),
+				binary.BigEndian.Uint16(sa[2:4]),
+			)
+			return
+		}
+		info, err := unix.GetsockoptIPv6MTUInfo(int(fd), unix.SOL_IPV6, ip6tSoOriginalDst)
+		if err != nil {
+			serr = err
+			return
+		}
+		// sin6_port is in network byte order as stored
+		var p [2]byte
+		binary.NativeEndian.PutUint16(p[:], info.Addr.Port)
+		dst = netip.AddrPortFrom(netip.AddrFrom16(info.Addr.Addr), binary.BigEndian.Uint16(p[:]))
+	})
+	if err != nil {
+		return netip.AddrPort{}, err
+	}
+	if serr != nil {
+		return netip.AddrPort{}, serr
+	}
+	return dst, nil
+}